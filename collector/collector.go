@@ -2,14 +2,18 @@
 package collector
 
 import (
+	"errors"
 	"fmt"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/billykwooten/go-ecobee/ecobee"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
 )
 
 type descs string
@@ -18,174 +22,1260 @@ func (d descs) new(fqName, help string, variableLabels []string) *prometheus.Des
 	return prometheus.NewDesc(fmt.Sprintf("%s_%s", d, fqName), help, variableLabels, nil)
 }
 
+// Client is the part of the Ecobee API the collector uses, implemented by
+// *ecobee.Client.
+type Client interface {
+	GetThermostats(selection ecobee.Selection) ([]ecobee.Thermostat, error)
+	GetThermostatSummary(selection ecobee.Selection) (map[string]ecobee.ThermostatSummary, error)
+}
+
 // eCollector implements prometheus.eCollector to gather ecobee metrics on-demand.
 type eCollector struct {
-	client *ecobee.Client
+	client Client
+
+	// equipment runtime accumulated across scrapes
+	runtimeTotals *runtimeTotals
+
+	// equipment cycles tracked across scrapes
+	cycles *equipmentCycles
+
+	// runtime reporting intervals observed across scrapes
+	reportIntervals *reportIntervals
+
+	// desired fan mode changes observed across scrapes
+	fanModeChanges *fanModeChanges
+
+	// last time every sensor reported readings, across scrapes
+	sensorsSeen *sensorsSeen
+
+	// unit temperature metrics are exported in
+	temperatureUnit TemperatureUnit
+
+	// whether metric names end with their unit
+	unitSuffixes bool
+
+	// format of thermostat and sensor names in label values
+	nameFormat NameFormat
+
+	// whether the sensor built into thermostats is left out of sensor metrics
+	skipOnboardSensor bool
+
+	// labels of sensor metrics, and the ones dropped from them
+	sensorLabels, droppedSensorLabels []string
+
+	// whether thermostats disconnected from the Ecobee servers are left out
+	skipDisconnected bool
+
+	// errors encountered while scraping, by stage
+	scrapeErrors *prometheus.CounterVec
+
+	// sensor readings that couldn't be parsed, by capability type
+	sensorParseErrors *prometheus.CounterVec
+
+	// go-ecobee token cache, exported as token metrics if set
+	tokenCacheFile string
+
+	// maximum time to wait for the Ecobee API, if positive
+	timeout time.Duration
+
+	// attempts made for every API call and the delay before the first retry
+	retryAttempts int
+	retryDelay    time.Duration
+	retries       *prometheus.CounterVec
+
+	// maximum number of Ecobee API calls made at the same time
+	concurrency int
+
+	// durations of every API call, including retries
+	fetchDuration *prometheus.HistogramVec
+
+	// requests made to every API endpoint
+	apiRequests *prometheus.CounterVec
+
+	// metric groups that are not exported
+	disabled map[MetricGroup]bool
+
+	// thermostats to export metrics for, matched by identifier or name
+	include, exclude *regexp.Regexp
+
+	// Ecobee API selection of the thermostats to fetch
+	selectionType, selectionMatch string
+
+	// how long API responses are reused for, if positive
+	cacheTTL time.Duration
+	cache    fetchCache
+
+	// API calls shared by concurrent scrapes
+	inflight inflightFetch
+
+	// outcome of the latest API calls
+	health health
 
 	// per-query descriptors
-	fetchTime *prometheus.Desc
+	fetchTime, up, lastSuccess, authorizationRevoked *prometheus.Desc
+
+	// authorization descriptors
+	tokenExpiry, tokenValid *prometheus.Desc
 
 	// runtime descriptors
-	actualTemperature, targetTemperatureMin, targetTemperatureMax *prometheus.Desc
+	actualTemperature, targetTemperatureMin, targetTemperatureMax, thermostatHumidity *prometheus.Desc
+	actualTemperatureRaw                                                              *prometheus.Desc
+	targetTemperatureDeadband, onboardTemperature, temperatureError                   *prometheus.Desc
+	desiredHumidity, desiredDehumidity, connected, info, desiredFanMode               *prometheus.Desc
+	fanModeChangesTotal, timeOffset                                                   *prometheus.Desc
+	lastModified, reportInterval                                                      *prometheus.Desc
+	heatRangeLow, heatRangeHigh, coolRangeLow, coolRangeHigh                          *prometheus.Desc
 
 	// sensor descriptors
-	temperature, humidity, occupancy, inUse, currentHvacMode *prometheus.Desc
+	temperature, rawTemperature, humidity, occupancy, inUse, lastSeen, currentHvacMode, hvacMode *prometheus.Desc
+	sensorCount, sensorOnlineCount, sensorOccupiedCount, capability, sensorInfo                  *prometheus.Desc
+	inActiveClimate                                                                              *prometheus.Desc
+
+	// air quality descriptors
+	airQuality, co2, voc *prometheus.Desc
+
+	// equipment descriptors
+	mode, equipmentRunning, runtimeSeconds, runtimeToday, cycleSeconds, cyclesTotal *prometheus.Desc
+
+	// program descriptors
+	currentClimate, climateHeatSetpoint, climateCoolSetpoint, holdActive, holdEnd *prometheus.Desc
+	holdInfo                                                                      *prometheus.Desc
+	scheduledTemperatureMin, scheduledTemperatureMax                              *prometheus.Desc
+	vacationActive, vacationEnd                                                   *prometheus.Desc
+	programInfo, programClimates, smartRecoveryActive                             *prometheus.Desc
+
+	// weather descriptors
+	outdoorTemperature, outdoorHumidity, windSpeed, pressure *prometheus.Desc
 }
 
 // NewEcobeeCollector returns a new eCollector with the given prefix assigned to all
 // metrics. Note that Prometheus metrics must be unique! Don't try to create
-// two Collectors with the same metric prefix.
-func NewEcobeeCollector(c *ecobee.Client, metricPrefix string) *eCollector {
+// two Collectors with the same metric prefix. The prefix must be a valid
+// Prometheus metric name.
+func NewEcobeeCollector(c Client, metricPrefix string, opts ...Option) (*eCollector, error) {
+	if !model.IsValidMetricName(model.LabelValue(metricPrefix)) {
+		return nil, fmt.Errorf("invalid metric prefix %q", metricPrefix)
+	}
 	d := descs(metricPrefix)
 
 	// fields common across multiple metrics
 	runtime := []string{"thermostat_id", "thermostat_name"}
-	sensor := append(runtime, "sensor_id", "sensor_name", "sensor_type")
 
-	return &eCollector{
-		client: c,
+	e := &eCollector{
+		client:          c,
+		runtimeTotals:   newRuntimeTotals(),
+		cycles:          newEquipmentCycles(),
+		reportIntervals: newReportIntervals(),
+		fanModeChanges:  newFanModeChanges(),
+		sensorsSeen:     newSensorsSeen(),
+		temperatureUnit: Fahrenheit,
+		nameFormat:      RawNames,
+		disabled:        map[MetricGroup]bool{},
+		scrapeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricPrefix,
+			Name:      "scrape_errors_total",
+			Help:      "errors encountered while fetching or parsing Ecobee API data",
+		}, []string{"stage"}),
+		sensorParseErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricPrefix,
+			Name:      "sensor_parse_errors_total",
+			Help:      "sensor readings that could not be parsed, by capability type",
+		}, []string{"type"}),
+		retryAttempts: 1,
+		concurrency:   1,
+		selectionType: "registered",
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricPrefix,
+			Name:      "api_retries_total",
+			Help:      "Ecobee API calls retried after a failure",
+		}, []string{"call"}),
+		fetchDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricPrefix,
+			Name:      "fetch_duration_seconds",
+			Help:      "time spent fetching data via Ecobee API, including retries",
+			Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"call"}),
+		apiRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricPrefix,
+			Name:      "api_requests_total",
+			Help:      "requests made to Ecobee API endpoints, including failed ones and retries",
+		}, []string{"endpoint"}),
 
 		// collector metrics
-		fetchTime: d.new(
-			"fetch_time",
-			"elapsed time fetching data via Ecobee API",
+		up: d.new(
+			"up",
+			"was the last Ecobee API call successful (0 or 1)",
+			[]string{"call"},
+		),
+		lastSuccess: d.new(
+			"last_scrape_success_timestamp_seconds",
+			"time data was last fetched via Ecobee API without any call failing",
+			nil,
+		),
+		authorizationRevoked: d.new(
+			"authorization_revoked",
+			"did the latest Ecobee API calls fail because the authorization of the exporter is missing, expired or revoked (0 or 1)",
+			nil,
+		),
+
+		// authorization metrics
+		tokenExpiry: d.new(
+			"token_expiry_timestamp_seconds",
+			"expiry time of the cached Ecobee API access token",
+			nil,
+		),
+		tokenValid: d.new(
+			"token_valid",
+			"is the cached Ecobee API access token present and unexpired (0 or 1)",
 			nil,
 		),
 
 		// thermostat (aka runtime) metrics
-		actualTemperature: d.new(
-			"actual_temperature",
-			"thermostat-averaged current temperature",
+		info: d.new(
+			"thermostat_info",
+			"thermostat hardware information (always 1)",
+			[]string{"thermostat_id", "thermostat_name", "model", "brand"},
+		),
+		connected: d.new(
+			"thermostat_connected",
+			"is thermostat connected to the Ecobee servers (0 or 1)",
+			runtime,
+		),
+		lastModified: d.new(
+			"thermostat_last_modified_timestamp_seconds",
+			"time the thermostat last sent its runtime data to the Ecobee servers",
+			runtime,
+		),
+		reportInterval: d.new(
+			"runtime_report_interval_seconds",
+			"latest interval between runtime reports of the thermostat to the Ecobee servers, as observed across scrapes",
 			runtime,
 		),
-		targetTemperatureMax: d.new(
-			"target_temperature_max",
-			"maximum temperature for thermostat to maintain",
+		actualTemperatureRaw: d.new(
+			"actual_temperature_raw",
+			"current temperature averaged by the thermostat as reported by the Ecobee API, in tenths of degrees Fahrenheit",
 			runtime,
 		),
-		targetTemperatureMin: d.new(
-			"target_temperature_min",
-			"minimum temperature for thermostat to maintain",
+		desiredFanMode: d.new(
+			"desired_fan_mode",
+			"fan mode the thermostat is set to (always 1)",
+			[]string{"thermostat_id", "thermostat_name", "fan_mode"},
+		),
+
+		timeOffset: d.new(
+			"thermostat_time_offset_seconds",
+			"how far the local time of the thermostat is ahead of UTC, as configured by its time zone",
+			runtime,
+		),
+		fanModeChangesTotal: d.new(
+			"fan_mode_changes_total",
+			"changes of the desired fan mode of thermostat seen between scrapes",
 			runtime,
 		),
 
-		// sensor metrics
-		temperature: d.new(
-			"temperature",
-			"temperature reported by a sensor in degrees",
-			sensor,
+		// sensor metrics, see newSensorDescs for the ones with sensor labels
+		sensorInfo: d.new(
+			"sensor_info",
+			"sensor names and types, for sensor metrics with dropped labels (always 1)",
+			sensorLabels,
 		),
-		humidity: d.new(
-			"humidity",
-			"humidity reported by a sensor in percent",
-			sensor,
+		sensorCount: d.new(
+			"thermostat_sensor_count",
+			"number of sensors paired with the thermostat",
+			runtime,
 		),
-		occupancy: d.new(
-			"occupancy",
-			"occupancy reported by a sensor (0 or 1)",
-			sensor,
+		sensorOnlineCount: d.new(
+			"thermostat_sensor_online_count",
+			"number of sensors paired with the thermostat that currently report readings",
+			runtime,
 		),
-		inUse: d.new(
-			"in_use",
-			"is sensor being used in thermostat calculations (0 or 1)",
-			sensor,
+		sensorOccupiedCount: d.new(
+			"thermostat_occupied_sensor_count",
+			"number of sensors paired with the thermostat that currently report occupancy",
+			runtime,
 		),
 		currentHvacMode: d.new(
 			"currenthvacmode",
 			"current hvac mode of thermostat",
 			[]string{"thermostat_id", "thermostat_name", "current_hvac_mode"},
 		),
+		hvacMode: d.new(
+			"hvac_mode",
+			"is the hvac mode of thermostat set to mode (0 or 1)",
+			[]string{"thermostat_id", "thermostat_name", "mode"},
+		),
+
+		// equipment (aka summary) metrics
+		mode: d.new(
+			"mode",
+			"is hvac equipment currently running (0 or 1)",
+			[]string{"thermostat_id", "thermostat_name", "mode"},
+		),
+		equipmentRunning: d.new(
+			"equipment_running",
+			"is hvac equipment currently running (0 or 1)",
+			[]string{"thermostat_id", "thermostat_name", "equipment"},
+		),
+		runtimeSeconds: d.new(
+			"runtime_seconds_total",
+			"total time hvac equipment has been running since the exporter started",
+			[]string{"thermostat_id", "thermostat_name", "equipment"},
+		),
+		runtimeToday: d.new(
+			"equipment_runtime_today_seconds",
+			"time hvac equipment has been running since midnight in the time zone of the thermostat, or since the exporter started if later",
+			[]string{"thermostat_id", "thermostat_name", "equipment"},
+		),
+		cycleSeconds: d.new(
+			"equipment_current_cycle_seconds",
+			"time hvac equipment has been running since it was first seen turned on, 0 while off",
+			[]string{"thermostat_id", "thermostat_name", "equipment"},
+		),
+		cyclesTotal: d.new(
+			"equipment_cycles_total",
+			"times hvac equipment was seen turning on between scrapes since the exporter started",
+			[]string{"thermostat_id", "thermostat_name", "equipment"},
+		),
+
+		// program metrics
+		programInfo: d.new(
+			"program_info",
+			"hash of the thermostat program schedule and climates, which changes when the thermostat is reprogrammed (always 1)",
+			[]string{"thermostat_id", "thermostat_name", "program_hash"},
+		),
+		programClimates: d.new(
+			"program_climates_count",
+			"number of climates (comfort settings) in the thermostat program",
+			runtime,
+		),
+		currentClimate: d.new(
+			"current_climate",
+			"climate (comfort setting) the thermostat program is currently running (always 1)",
+			[]string{"thermostat_id", "thermostat_name", "climate_ref"},
+		),
+		holdActive: d.new(
+			"hold_active",
+			"is an event overriding the thermostat program running (always 1)",
+			[]string{"thermostat_id", "thermostat_name", "event_type"},
+		),
+		holdInfo: d.new(
+			"hold_info",
+			"climate a running event holds the thermostat at, empty for custom setpoints, and the fan mode it runs (always 1)",
+			[]string{"thermostat_id", "thermostat_name", "event_type", "climate_ref", "fan"},
+		),
+		holdEnd: d.new(
+			"hold_end_timestamp_seconds",
+			"time a running event overriding the thermostat program ends",
+			[]string{"thermostat_id", "thermostat_name", "event_type"},
+		),
+
+		vacationActive: d.new(
+			"vacation_active",
+			"is a vacation event running on the thermostat (0 or 1)",
+			runtime,
+		),
+		vacationEnd: d.new(
+			"vacation_end_timestamp_seconds",
+			"time the running vacation event ends",
+			runtime,
+		),
+		smartRecoveryActive: d.new(
+			"smart_recovery_active",
+			"does smart recovery appear to run the system ahead of the next scheduled climate, derived from the desired setpoints (0 or 1)",
+			runtime,
+		),
+	}
+	for _, stage := range scrapeStages {
+		e.scrapeErrors.WithLabelValues(stage)
+	}
+	for _, typ := range parsedCapabilities {
+		e.sensorParseErrors.WithLabelValues(typ)
+	}
+	for _, call := range []string{"get_thermostats", "get_thermostat_summary"} {
+		e.retries.WithLabelValues(call)
+		e.fetchDuration.WithLabelValues(call)
+	}
+	for _, endpoint := range []string{"thermostat", "thermostatSummary"} {
+		e.apiRequests.WithLabelValues(endpoint)
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.unitSuffixes && e.temperatureUnit == BothUnits {
+		return nil, errors.New("unit suffixes need a single temperature unit")
+	}
+	for _, l := range e.droppedSensorLabels {
+		if !droppableSensorLabel(l) {
+			return nil, fmt.Errorf("sensor label %q can't be dropped", l)
+		}
+	}
+	for _, l := range sensorLabels {
+		if !e.sensorLabelDropped(l) {
+			e.sensorLabels = append(e.sensorLabels, l)
+		}
+	}
+	e.newUnitDescs(d)
+	e.newSensorDescs(d)
+	return e, nil
+}
+
+// newUnitDescs creates the descriptors of metrics whose name or labels depend
+// on the configured units: with unit suffixes their unit is part of their
+// name, and temperatures have an additional unit label when exporting both
+// units.
+func (c *eCollector) newUnitDescs(d descs) {
+	labels := []string{"thermostat_id", "thermostat_name"}
+	runtime := c.temperatureLabels(labels...)
+	climate := c.temperatureLabels("thermostat_id", "thermostat_name", "climate_ref")
+	c.fetchTime = d.new(
+		c.unitName("fetch_time", "seconds"),
+		"elapsed time fetching data via Ecobee API",
+		[]string{"call"},
+	)
+	c.thermostatHumidity = d.new(
+		c.unitName("thermostat_humidity", "percent"),
+		"humidity reported by the thermostat in percent",
+		labels,
+	)
+	c.desiredHumidity = d.new(
+		c.unitName("desired_humidity", "percent"),
+		"humidity for the humidifier to maintain in percent",
+		labels,
+	)
+	c.desiredDehumidity = d.new(
+		c.unitName("desired_dehumidity", "percent"),
+		"humidity for the dehumidifier to maintain in percent",
+		labels,
+	)
+	c.outdoorHumidity = d.new(
+		c.unitName("weather_outdoor_humidity", "percent"),
+		"current outdoor humidity at the thermostat location in percent",
+		labels,
+	)
+	c.actualTemperature = d.new(
+		c.unitName("actual_temperature", c.temperatureSuffix()),
+		c.temperatureHelp("current temperature averaged by the thermostat across the sensors in use"),
+		runtime,
+	)
+	c.onboardTemperature = d.new(
+		c.unitName("onboard_temperature", c.temperatureSuffix()),
+		c.temperatureHelp("current temperature reported by the sensor built into the thermostat"),
+		runtime,
+	)
+	c.targetTemperatureMax = d.new(
+		c.unitName("target_temperature_max", c.temperatureSuffix()),
+		c.temperatureHelp("maximum temperature for thermostat to maintain"),
+		runtime,
+	)
+	c.targetTemperatureMin = d.new(
+		c.unitName("target_temperature_min", c.temperatureSuffix()),
+		c.temperatureHelp("minimum temperature for thermostat to maintain"),
+		runtime,
+	)
+	c.targetTemperatureDeadband = d.new(
+		c.unitName("target_temperature_deadband", c.temperatureSuffix()),
+		c.temperatureHelp("difference between the maximum and minimum temperature for thermostat to maintain"),
+		runtime,
+	)
+	c.heatRangeLow = d.new(
+		c.unitName("heat_setpoint_limit_min", c.temperatureSuffix()),
+		c.temperatureHelp("lowest heat setpoint the thermostat allows"),
+		runtime,
+	)
+	c.heatRangeHigh = d.new(
+		c.unitName("heat_setpoint_limit_max", c.temperatureSuffix()),
+		c.temperatureHelp("highest heat setpoint the thermostat allows"),
+		runtime,
+	)
+	c.coolRangeLow = d.new(
+		c.unitName("cool_setpoint_limit_min", c.temperatureSuffix()),
+		c.temperatureHelp("lowest cool setpoint the thermostat allows"),
+		runtime,
+	)
+	c.coolRangeHigh = d.new(
+		c.unitName("cool_setpoint_limit_max", c.temperatureSuffix()),
+		c.temperatureHelp("highest cool setpoint the thermostat allows"),
+		runtime,
+	)
+	c.temperatureError = d.new(
+		c.unitName("temperature_error", c.temperatureSuffix()),
+		c.temperatureHelp("how far the actual temperature is above (positive) or below (negative) the setpoints of the current hvac mode, 0 within them,"),
+		runtime,
+	)
+	c.climateHeatSetpoint = d.new(
+		c.unitName("climate_heat_setpoint", c.temperatureSuffix()),
+		c.temperatureHelp("minimum temperature programmed for a climate (comfort setting)"),
+		climate,
+	)
+	c.climateCoolSetpoint = d.new(
+		c.unitName("climate_cool_setpoint", c.temperatureSuffix()),
+		c.temperatureHelp("maximum temperature programmed for a climate (comfort setting)"),
+		climate,
+	)
+	c.scheduledTemperatureMin = d.new(
+		c.unitName("scheduled_target_temperature_min", c.temperatureSuffix()),
+		c.temperatureHelp("minimum temperature the thermostat program schedules for now, regardless of holds,"),
+		runtime,
+	)
+	c.scheduledTemperatureMax = d.new(
+		c.unitName("scheduled_target_temperature_max", c.temperatureSuffix()),
+		c.temperatureHelp("maximum temperature the thermostat program schedules for now, regardless of holds,"),
+		runtime,
+	)
+	c.outdoorTemperature = d.new(
+		c.unitName("weather_outdoor_temperature", c.temperatureSuffix()),
+		c.temperatureHelp("current outdoor temperature at the thermostat location"),
+		runtime,
+	)
+	c.windSpeed = d.new(
+		c.unitName("weather_wind_speed", "mph"),
+		"current wind speed at the thermostat location in mph",
+		labels,
+	)
+	c.pressure = d.new(
+		c.unitName("weather_pressure", "millibars"),
+		"current barometric pressure at the thermostat location in millibars",
+		labels,
+	)
+}
+
+// sensorLabels lists the labels of sensor metrics.
+var sensorLabels = []string{"thermostat_id", "thermostat_name", "sensor_id", "sensor_name", "sensor_type"}
+
+// DroppableSensorLabels lists the sensor labels that can be dropped with
+// WithoutSensorLabels, as the remaining ones still identify every sensor.
+var DroppableSensorLabels = []string{"thermostat_name", "sensor_name", "sensor_type"}
+
+func droppableSensorLabel(label string) bool {
+	for _, l := range DroppableSensorLabels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *eCollector) sensorLabelDropped(label string) bool {
+	for _, l := range c.droppedSensorLabels {
+		if l == label {
+			return true
+		}
 	}
+	return false
+}
+
+// sensorLabelValues returns the values of the sensor labels of s, a sensor
+// of t.
+func (c *eCollector) sensorLabelValues(t Thermostat, s Sensor) []string {
+	values := map[string]string{
+		"thermostat_id":   t.ID,
+		"thermostat_name": t.Name,
+		"sensor_id":       s.ID,
+		"sensor_name":     s.Name,
+		"sensor_type":     s.Type,
+	}
+	v := make([]string, 0, len(c.sensorLabels))
+	for _, l := range c.sensorLabels {
+		v = append(v, values[l])
+	}
+	return v
+}
+
+// newSensorDescs creates the descriptors of sensor metrics, which are
+// labelled with the sensor labels that weren't dropped.
+func (c *eCollector) newSensorDescs(d descs) {
+	labels := c.sensorLabels
+	c.temperature = d.new(
+		c.unitName("temperature", c.temperatureSuffix()),
+		c.temperatureHelp("temperature reported by a sensor"),
+		c.temperatureLabels(labels...),
+	)
+	c.rawTemperature = d.new(
+		"temperature_raw",
+		"uncalibrated temperature value reported by a sensor, in tenths of degrees Fahrenheit",
+		labels,
+	)
+	c.humidity = d.new(
+		c.unitName("humidity", "percent"),
+		"humidity reported by a sensor in percent",
+		labels,
+	)
+	c.occupancy = d.new(
+		"occupancy",
+		"occupancy reported by a sensor (0 or 1)",
+		labels,
+	)
+	c.inUse = d.new(
+		"in_use",
+		"is sensor being used in thermostat calculations (0 or 1)",
+		labels,
+	)
+	c.inActiveClimate = d.new(
+		"sensor_in_active_climate",
+		"is sensor taken into account by the climate (comfort setting) currently in effect (0 or 1)",
+		labels,
+	)
+	c.airQuality = d.new(
+		"air_quality_index",
+		"air quality score reported by a sensor",
+		labels,
+	)
+	c.co2 = d.new(
+		"co2_ppm",
+		"carbon dioxide concentration reported by a sensor in parts per million",
+		labels,
+	)
+	c.voc = d.new(
+		"voc_ppb",
+		"volatile organic compound concentration reported by a sensor in parts per billion",
+		labels,
+	)
+	c.capability = d.new(
+		"sensor_capability",
+		"numeric value of a sensor capability without a dedicated metric",
+		append(labels[:len(labels):len(labels)], "type"),
+	)
+	c.lastSeen = d.new(
+		"sensor_last_seen_timestamp_seconds",
+		"time the sensor last reported any readings via the Ecobee API since the exporter started",
+		labels,
+	)
+}
+
+// scrapeStages lists the stage label values of the scrape errors counter, so
+// that every series is exported from the start.
+var scrapeStages = []string{
+	"get_thermostats",
+	"get_thermostat_summary",
+	"authorization",
+	"timeout",
+	"read_token",
+	"parse_runtime",
+	"parse_events",
+	"parse_temperature",
+	"implausible_temperature",
+	"parse_humidity",
+	"parse_occupancy",
+	"parse_air_quality",
 }
 
+// parsedCapabilities lists the sensor capability types with a dedicated
+// metric, whose sensor parse errors are exported from the start.
+var parsedCapabilities = []string{"temperature", "humidity", "occupancy", "airQuality", "co2", "co2PPM", "vocPPM"}
+
+// hvacModes lists the hvac modes a thermostat can be set to.
+var hvacModes = []string{"auto", "auxHeatOnly", "cool", "heat", "off"}
+
 // Describe dumps all metric descriptors into ch.
 func (c *eCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.fetchTime
-	ch <- c.actualTemperature
-	ch <- c.targetTemperatureMax
-	ch <- c.targetTemperatureMin
-	ch <- c.temperature
-	ch <- c.humidity
-	ch <- c.occupancy
-	ch <- c.inUse
-	ch <- c.currentHvacMode
+	ch <- c.up
+	ch <- c.lastSuccess
+	ch <- c.authorizationRevoked
+	if c.tokenCacheFile != "" {
+		ch <- c.tokenExpiry
+		ch <- c.tokenValid
+	}
+	ch <- c.info
+	ch <- c.connected
+	if c.enabled(RuntimeMetrics) {
+		ch <- c.actualTemperature
+		ch <- c.actualTemperatureRaw
+		ch <- c.targetTemperatureMax
+		ch <- c.targetTemperatureMin
+		ch <- c.targetTemperatureDeadband
+		ch <- c.heatRangeLow
+		ch <- c.heatRangeHigh
+		ch <- c.coolRangeLow
+		ch <- c.coolRangeHigh
+		ch <- c.temperatureError
+		ch <- c.thermostatHumidity
+		ch <- c.desiredHumidity
+		ch <- c.desiredDehumidity
+		ch <- c.desiredFanMode
+		ch <- c.fanModeChangesTotal
+		ch <- c.timeOffset
+		ch <- c.currentHvacMode
+		ch <- c.hvacMode
+		ch <- c.lastModified
+		ch <- c.reportInterval
+	}
+	if c.enabled(SensorMetrics) {
+		ch <- c.onboardTemperature
+		ch <- c.temperature
+		ch <- c.rawTemperature
+		ch <- c.humidity
+		ch <- c.occupancy
+		ch <- c.airQuality
+		ch <- c.co2
+		ch <- c.voc
+		ch <- c.capability
+		ch <- c.inUse
+		ch <- c.inActiveClimate
+		ch <- c.lastSeen
+		ch <- c.sensorCount
+		if len(c.droppedSensorLabels) > 0 {
+			ch <- c.sensorInfo
+		}
+		ch <- c.sensorOnlineCount
+		ch <- c.sensorOccupiedCount
+	}
+	if c.enabled(EquipmentMetrics) {
+		ch <- c.mode
+		ch <- c.equipmentRunning
+		ch <- c.runtimeSeconds
+		ch <- c.runtimeToday
+		ch <- c.cycleSeconds
+		ch <- c.cyclesTotal
+	}
+	if c.enabled(ProgramMetrics) {
+		ch <- c.programInfo
+		ch <- c.programClimates
+		ch <- c.currentClimate
+		ch <- c.climateHeatSetpoint
+		ch <- c.climateCoolSetpoint
+		ch <- c.scheduledTemperatureMin
+		ch <- c.scheduledTemperatureMax
+		ch <- c.holdActive
+		ch <- c.holdInfo
+		ch <- c.holdEnd
+		ch <- c.vacationActive
+		ch <- c.vacationEnd
+		ch <- c.smartRecoveryActive
+	}
+	if c.enabled(WeatherMetrics) {
+		ch <- c.outdoorTemperature
+		ch <- c.outdoorHumidity
+		ch <- c.windSpeed
+		ch <- c.pressure
+	}
+	c.scrapeErrors.Describe(ch)
+	c.sensorParseErrors.Describe(ch)
+	c.retries.Describe(ch)
+	c.fetchDuration.Describe(ch)
+	c.apiRequests.Describe(ch)
 }
 
 // Collect retrieves thermostat data via the ecobee API.
 func (c *eCollector) Collect(ch chan<- prometheus.Metric) {
-	start := time.Now()
-	tt, err := c.client.GetThermostats(ecobee.Selection{
-		SelectionType:   "registered",
-		IncludeSensors:  true,
-		IncludeRuntime:  true,
-		IncludeSettings: true,
-	})
-	elapsed := time.Now().Sub(start)
-	ch <- prometheus.MustNewConstMetric(c.fetchTime, prometheus.GaugeValue, elapsed.Seconds())
-	if err != nil {
-		log.Error(err)
-		return
-	}
-	for _, t := range tt {
-		tFields := []string{t.Identifier, t.Name}
-		if t.Runtime.Connected {
+	m := c.cachedFetch().metrics
+	for _, call := range m.Calls {
+		ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, boolToFloat(call.Err == nil), call.Name)
+	}
+	for _, call := range m.Calls {
+		ch <- prometheus.MustNewConstMetric(c.fetchTime, prometheus.GaugeValue, call.Elapsed.Seconds(), call.Name)
+	}
+	if t := c.health.lastSuccessful(); !t.IsZero() {
+		ch <- prometheus.MustNewConstMetric(c.lastSuccess, prometheus.GaugeValue, float64(t.Unix()))
+	}
+	ch <- prometheus.MustNewConstMetric(c.authorizationRevoked, prometheus.GaugeValue, boolToFloat(c.health.authorizationRevoked()))
+	if c.tokenCacheFile != "" {
+		// read after the API calls, which refresh the token when needed
+		tok, err := readCachedToken(c.tokenCacheFile)
+		if err == nil {
+			ch <- prometheus.MustNewConstMetric(c.tokenExpiry, prometheus.GaugeValue, float64(tok.Expiry.Unix()))
+		} else {
+			log.Error(err)
+			c.scrapeErrors.WithLabelValues("read_token").Inc()
+		}
+		ch <- prometheus.MustNewConstMetric(c.tokenValid, prometheus.GaugeValue, boolToFloat(err == nil && tok.Valid()))
+	}
+	if c.enabled(EquipmentMetrics) {
+		for _, e := range m.Equipment {
+			c.collectEquipment(ch, e, m.FetchedAt)
+		}
+	}
+	for _, t := range m.Thermostats {
+		tFields := []string{t.ID, t.Name}
+		ch <- prometheus.MustNewConstMetric(
+			c.info, prometheus.GaugeValue, 1, t.ID, t.Name, t.Model, t.Brand,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.connected, prometheus.GaugeValue, boolToFloat(t.Connected), tFields...,
+		)
+		// also exported while disconnected, to tell how stale the data is
+		if c.enabled(RuntimeMetrics) && !t.LastModified.IsZero() {
 			ch <- prometheus.MustNewConstMetric(
-				c.actualTemperature, prometheus.GaugeValue, float64(t.Runtime.ActualTemperature)/10, tFields...,
+				c.lastModified, prometheus.GaugeValue, float64(t.LastModified.Unix()), tFields...,
 			)
+			if interval, ok := c.reportIntervals.update(t.ID, t.LastModified); ok {
+				ch <- prometheus.MustNewConstMetric(
+					c.reportInterval, prometheus.GaugeValue, interval.Seconds(), tFields...,
+				)
+			}
+		}
+		if c.enabled(RuntimeMetrics) && t.Connected {
+			c.collectRuntime(ch, t)
+		}
+		if c.enabled(EquipmentMetrics) {
+			c.collectRuntimeTotals(ch, t)
+		}
+		if c.enabled(ProgramMetrics) {
+			c.collectProgram(ch, t, m.FetchedAt)
+		}
+		if c.enabled(WeatherMetrics) {
+			c.collectWeather(ch, t)
+		}
+		if c.enabled(SensorMetrics) {
+			c.collectSensors(ch, t, m.FetchedAt)
+		}
+	}
+	c.scrapeErrors.Collect(ch)
+	c.sensorParseErrors.Collect(ch)
+	c.retries.Collect(ch)
+	c.fetchDuration.Collect(ch)
+	c.apiRequests.Collect(ch)
+}
+
+// onboardSensorType is the type of the sensor built into a thermostat.
+const onboardSensorType = "thermostat"
+
+// equipmentModes maps the values of the mode label to the equipment they
+// report the status of.
+var equipmentModes = []struct{ mode, equipment string }{
+	{"fan", "fan"},
+	{"cool", "compCool1"},
+	{"cool2", "compCool2"},
+	{"heat", "heatPump"},
+	{"heat2", "heatPump2"},
+	{"aux", "auxHeat1"},
+	{"aux1", "auxHeat1"},
+	{"aux2", "auxHeat2"},
+	{"aux3", "auxHeat3"},
+	{"humidifier", "humidifier"},
+	{"dehumidifier", "dehumidifier"},
+	{"ventilator", "ventilator"},
+	{"economizer", "economizer"},
+}
+
+// collectEquipment emits the equipment status of a thermostat, as returned
+// by the fetch at fetchedAt.
+func (c *eCollector) collectEquipment(ch chan<- prometheus.Metric, e EquipmentStatus, fetchedAt time.Time) {
+	for _, m := range equipmentModes {
+		ch <- prometheus.MustNewConstMetric(
+			c.mode, prometheus.GaugeValue, boolToFloat(e.Running[m.equipment]), e.ThermostatID, e.ThermostatName, m.mode,
+		)
+	}
+	for equipment, running := range e.Running {
+		ch <- prometheus.MustNewConstMetric(
+			c.equipmentRunning, prometheus.GaugeValue, boolToFloat(running), e.ThermostatID, e.ThermostatName, equipment,
+		)
+	}
+	seconds, cycles := c.cycles.update(e.ThermostatID, e.Running, fetchedAt)
+	for equipment, v := range seconds {
+		ch <- prometheus.MustNewConstMetric(
+			c.cycleSeconds, prometheus.GaugeValue, v, e.ThermostatID, e.ThermostatName, equipment,
+		)
+	}
+	for equipment, v := range cycles {
+		ch <- prometheus.MustNewConstMetric(
+			c.cyclesTotal, prometheus.CounterValue, v, e.ThermostatID, e.ThermostatName, equipment,
+		)
+	}
+}
+
+// collectRuntime emits the runtime metrics of a connected thermostat.
+func (c *eCollector) collectRuntime(ch chan<- prometheus.Metric, t Thermostat) {
+	tFields := []string{t.ID, t.Name}
+	c.collectTemperature(ch, c.actualTemperature, t.Runtime.ActualTemperature, tFields...)
+	ch <- prometheus.MustNewConstMetric(
+		c.actualTemperatureRaw, prometheus.GaugeValue, rawTemperature(t.Runtime.ActualTemperature), tFields...,
+	)
+	c.collectTemperature(ch, c.targetTemperatureMax, t.Runtime.DesiredCool, tFields...)
+	c.collectTemperature(ch, c.targetTemperatureMin, t.Runtime.DesiredHeat, tFields...)
+	if t.Runtime.DesiredCool != 0 && t.Runtime.DesiredHeat != 0 {
+		c.collectTemperatureDifference(ch, c.targetTemperatureDeadband, t.Runtime.DesiredCool-t.Runtime.DesiredHeat, tFields...)
+	}
+	if r := t.Runtime.HeatRange; r != nil {
+		c.collectTemperature(ch, c.heatRangeLow, r[0], tFields...)
+		c.collectTemperature(ch, c.heatRangeHigh, r[1], tFields...)
+	}
+	if r := t.Runtime.CoolRange; r != nil {
+		c.collectTemperature(ch, c.coolRangeLow, r[0], tFields...)
+		c.collectTemperature(ch, c.coolRangeHigh, r[1], tFields...)
+	}
+	if v, ok := temperatureError(t.HvacMode, t.Runtime); ok {
+		c.collectTemperatureDifference(ch, c.temperatureError, v, tFields...)
+	}
+	ch <- prometheus.MustNewConstMetric(
+		c.thermostatHumidity, prometheus.GaugeValue, t.Runtime.ActualHumidity, tFields...,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.desiredHumidity, prometheus.GaugeValue, t.Runtime.DesiredHumidity, tFields...,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.desiredDehumidity, prometheus.GaugeValue, t.Runtime.DesiredDehumidity, tFields...,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.desiredFanMode, prometheus.GaugeValue, 1, t.ID, t.Name, t.Runtime.DesiredFanMode,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.fanModeChangesTotal, prometheus.CounterValue, c.fanModeChanges.update(t.ID, t.Runtime.DesiredFanMode), tFields...,
+	)
+	if t.TimeOffset != nil {
+		ch <- prometheus.MustNewConstMetric(
+			c.timeOffset, prometheus.GaugeValue, t.TimeOffset.Seconds(), tFields...,
+		)
+	}
+	// settings can be missing from the response, don't export an empty mode
+	if t.HvacMode != "" {
+		ch <- prometheus.MustNewConstMetric(
+			c.currentHvacMode, prometheus.GaugeValue, 0, t.ID, t.Name, t.HvacMode,
+		)
+		for _, m := range hvacModes {
 			ch <- prometheus.MustNewConstMetric(
-				c.targetTemperatureMax, prometheus.GaugeValue, float64(t.Runtime.DesiredCool)/10, tFields...,
+				c.hvacMode, prometheus.GaugeValue, boolToFloat(t.HvacMode == m), t.ID, t.Name, m,
 			)
+		}
+	}
+}
+
+// collectRuntimeTotals emits the equipment runtime accumulated for a thermostat.
+func (c *eCollector) collectRuntimeTotals(ch chan<- prometheus.Metric, t Thermostat) {
+	// days are UTC days until the time zone of the thermostat is known
+	var offset time.Duration
+	if t.TimeOffset != nil {
+		offset = *t.TimeOffset
+	}
+	totals, today := c.runtimeTotals.update(t.ID, t.ExtendedRuntime, offset)
+	for equipment, v := range totals {
+		ch <- prometheus.MustNewConstMetric(
+			c.runtimeSeconds, prometheus.CounterValue, v, t.ID, t.Name, equipment,
+		)
+	}
+	for equipment, v := range today {
+		ch <- prometheus.MustNewConstMetric(
+			c.runtimeToday, prometheus.GaugeValue, v, t.ID, t.Name, equipment,
+		)
+	}
+}
+
+// collectProgram emits the program and event metrics of a thermostat.
+func (c *eCollector) collectProgram(ch chan<- prometheus.Metric, t Thermostat, fetchedAt time.Time) {
+	ch <- prometheus.MustNewConstMetric(
+		c.programInfo, prometheus.GaugeValue, 1, t.ID, t.Name, t.Program.Hash,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.programClimates, prometheus.GaugeValue, float64(len(t.Program.Climates)), t.ID, t.Name,
+	)
+	if t.Program.CurrentClimate != "" {
+		ch <- prometheus.MustNewConstMetric(
+			c.currentClimate, prometheus.GaugeValue, 1, t.ID, t.Name, t.Program.CurrentClimate,
+		)
+	}
+	for _, cl := range t.Program.Climates {
+		c.collectTemperature(ch, c.climateHeatSetpoint, cl.HeatSetpoint, t.ID, t.Name, cl.Ref)
+		c.collectTemperature(ch, c.climateCoolSetpoint, cl.CoolSetpoint, t.ID, t.Name, cl.Ref)
+		// desired temperatures in the runtime reflect holds, these don't
+		if cl.Ref == t.Program.CurrentClimate {
+			c.collectTemperature(ch, c.scheduledTemperatureMin, cl.HeatSetpoint, t.ID, t.Name)
+			c.collectTemperature(ch, c.scheduledTemperatureMax, cl.CoolSetpoint, t.ID, t.Name)
+		}
+	}
+	vacation := false
+	for _, e := range t.Program.Events {
+		ch <- prometheus.MustNewConstMetric(
+			c.holdActive, prometheus.GaugeValue, 1, t.ID, t.Name, e.Type,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.holdInfo, prometheus.GaugeValue, 1, t.ID, t.Name, e.Type, e.ClimateRef, e.Fan,
+		)
+		if !e.End.IsZero() {
 			ch <- prometheus.MustNewConstMetric(
-				c.targetTemperatureMin, prometheus.GaugeValue, float64(t.Runtime.DesiredHeat)/10, tFields...,
+				c.holdEnd, prometheus.GaugeValue, float64(e.End.Unix()), t.ID, t.Name, e.Type,
 			)
+		}
+		if e.Type != "vacation" {
+			continue
+		}
+		vacation = true
+		if !e.End.IsZero() {
 			ch <- prometheus.MustNewConstMetric(
-				c.currentHvacMode, prometheus.GaugeValue, 0, t.Identifier, t.Name, t.Settings.HvacMode,
+				c.vacationEnd, prometheus.GaugeValue, float64(e.End.Unix()), t.ID, t.Name,
 			)
 		}
-		for _, s := range t.RemoteSensors {
-			sFields := append(tFields, s.ID, s.Name, s.Type)
-			inUse := float64(0)
-			if s.InUse {
-				inUse = 1
+	}
+	ch <- prometheus.MustNewConstMetric(
+		c.vacationActive, prometheus.GaugeValue, boolToFloat(vacation), t.ID, t.Name,
+	)
+	if active, ok := smartRecovery(t, fetchedAt); ok {
+		ch <- prometheus.MustNewConstMetric(
+			c.smartRecoveryActive, prometheus.GaugeValue, boolToFloat(active), t.ID, t.Name,
+		)
+	}
+}
+
+// collectWeather emits the current weather at the location of a thermostat.
+func (c *eCollector) collectWeather(ch chan<- prometheus.Metric, t Thermostat) {
+	tFields := []string{t.ID, t.Name}
+	if w := t.Weather; w != nil {
+		c.collectTemperature(ch, c.outdoorTemperature, w.Temperature, tFields...)
+		ch <- prometheus.MustNewConstMetric(
+			c.outdoorHumidity, prometheus.GaugeValue, w.Humidity, tFields...,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.windSpeed, prometheus.GaugeValue, w.WindSpeed, tFields...,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.pressure, prometheus.GaugeValue, w.Pressure, tFields...,
+		)
+	}
+}
+
+// collectSensors emits the metrics of every sensor of a thermostat, as
+// returned by the fetch at fetchedAt.
+func (c *eCollector) collectSensors(ch chan<- prometheus.Metric, t Thermostat, fetchedAt time.Time) {
+	tFields := []string{t.ID, t.Name}
+	online, occupied := 0, 0
+	// climates are only known while program metrics are enabled
+	climate, climateKnown := activeClimate(t)
+	for _, s := range t.Sensors {
+		sFields := c.sensorLabelValues(t, s)
+		if s.Online {
+			online++
+		}
+		if s.Occupied != nil && *s.Occupied {
+			occupied++
+		}
+		if s.Type == onboardSensorType {
+			if v := s.Temperature; v != nil {
+				c.collectTemperature(ch, c.onboardTemperature, *v, tFields...)
+			}
+			if c.skipOnboardSensor {
+				continue
 			}
+		}
+		if len(c.droppedSensorLabels) > 0 {
 			ch <- prometheus.MustNewConstMetric(
-				c.inUse, prometheus.GaugeValue, inUse, sFields...,
+				c.sensorInfo, prometheus.GaugeValue, 1, t.ID, t.Name, s.ID, s.Name, s.Type,
+			)
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.inUse, prometheus.GaugeValue, boolToFloat(s.InUse), sFields...,
+		)
+		if climateKnown {
+			ch <- prometheus.MustNewConstMetric(
+				c.inActiveClimate, prometheus.GaugeValue, boolToFloat(climateSensor(climate, s.ID)), sFields...,
+			)
+		}
+		if seen, ok := c.sensorsSeen.update(t.ID, s.ID, s.Online, fetchedAt); ok {
+			ch <- prometheus.MustNewConstMetric(
+				c.lastSeen, prometheus.GaugeValue, float64(seen.Unix()), sFields...,
+			)
+		}
+		if v := s.Temperature; v != nil {
+			c.collectTemperature(ch, c.temperature, *v, sFields...)
+			ch <- prometheus.MustNewConstMetric(
+				c.rawTemperature, prometheus.GaugeValue, rawTemperature(*v), sFields...,
 			)
-			for _, sc := range s.Capability {
-				switch sc.Type {
-				case "temperature":
-					if v, err := strconv.ParseFloat(sc.Value, 64); err == nil {
-						ch <- prometheus.MustNewConstMetric(
-							c.temperature, prometheus.GaugeValue, v/10, sFields...,
-						)
-					} else {
-						log.Error(err)
-					}
-				case "humidity":
-					if v, err := strconv.ParseFloat(sc.Value, 64); err == nil {
-						ch <- prometheus.MustNewConstMetric(
-							c.humidity, prometheus.GaugeValue, v, sFields...,
-						)
-					} else {
-						log.Error(err)
-					}
-				case "occupancy":
-					switch sc.Value {
-					case "true":
-						ch <- prometheus.MustNewConstMetric(
-							c.occupancy, prometheus.GaugeValue, 1, sFields...,
-						)
-					case "false":
-						ch <- prometheus.MustNewConstMetric(
-							c.occupancy, prometheus.GaugeValue, 0, sFields...,
-						)
-					default:
-						log.Errorf("unknown sensor occupancy value %q", sc.Value)
-					}
-				default:
-					log.Infof("ignoring sensor capability %q", sc.Type)
-				}
-			}
 		}
+		if v := s.Humidity; v != nil {
+			ch <- prometheus.MustNewConstMetric(c.humidity, prometheus.GaugeValue, *v, sFields...)
+		}
+		if v := s.Occupied; v != nil {
+			ch <- prometheus.MustNewConstMetric(c.occupancy, prometheus.GaugeValue, boolToFloat(*v), sFields...)
+		}
+		if v := s.AirQuality; v != nil {
+			ch <- prometheus.MustNewConstMetric(c.airQuality, prometheus.GaugeValue, *v, sFields...)
+		}
+		if v := s.CO2; v != nil {
+			ch <- prometheus.MustNewConstMetric(c.co2, prometheus.GaugeValue, *v, sFields...)
+		}
+		if v := s.VOC; v != nil {
+			ch <- prometheus.MustNewConstMetric(c.voc, prometheus.GaugeValue, *v, sFields...)
+		}
+		for typ, v := range s.Capabilities {
+			ch <- prometheus.MustNewConstMetric(
+				c.capability, prometheus.GaugeValue, v, append(sFields, typ)...,
+			)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(
+		c.sensorCount, prometheus.GaugeValue, float64(len(t.Sensors)), tFields...,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.sensorOnlineCount, prometheus.GaugeValue, float64(online), tFields...,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.sensorOccupiedCount, prometheus.GaugeValue, float64(occupied), tFields...,
+	)
+}
+
+// temperatureError returns how far the actual temperature of a thermostat in
+// the given hvac mode is above (positive) or below (negative) the setpoints
+// that mode maintains, or 0 between them. There is no error while the
+// thermostat is off.
+func temperatureError(hvacMode string, r Runtime) (float64, bool) {
+	heat := r.ActualTemperature - r.DesiredHeat
+	cool := r.ActualTemperature - r.DesiredCool
+	switch hvacMode {
+	case "heat", "auxHeatOnly":
+		return heat, true
+	case "cool":
+		return cool, true
+	case "auto":
+		switch {
+		case heat < 0:
+			return heat, true
+		case cool > 0:
+			return cool, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// enabled reports whether metrics of group g are exported.
+func (c *eCollector) enabled(g MetricGroup) bool {
+	return !c.disabled[g]
+}
+
+// collected reports whether metrics are exported for the thermostat with
+// the given identifier and name.
+func (c *eCollector) collected(id, name string) bool {
+	if c.include != nil && !c.include.MatchString(id) && !c.include.MatchString(name) {
+		return false
+	}
+	if c.exclude != nil && (c.exclude.MatchString(id) || c.exclude.MatchString(name)) {
+		return false
+	}
+	return true
+}
+
+// unitName returns the name of a metric in unit, with the unit appended when
+// unit suffixes are enabled and the name doesn't end with it already.
+func (c *eCollector) unitName(name, unit string) string {
+	if !c.unitSuffixes || strings.HasSuffix(name, "_"+unit) {
+		return name
+	}
+	return name + "_" + unit
+}
+
+// temperatureSuffix returns the unit suffix of temperature metrics.
+func (c *eCollector) temperatureSuffix() string {
+	if c.temperatureUnit == Celsius {
+		return "celsius"
+	}
+	return "fahrenheit"
+}
+
+// temperatureHelp completes the help of a temperature metric with the unit it
+// is exported in. The unit is the same for all thermostats, as go-ecobee
+// doesn't decode the useCelsius setting thermostats display temperatures with.
+func (c *eCollector) temperatureHelp(help string) string {
+	switch c.temperatureUnit {
+	case Celsius:
+		return help + " in degrees Celsius"
+	case BothUnits:
+		return help + " in the degrees given by the unit label"
+	default:
+		return help + " in degrees Fahrenheit"
+	}
+}
+
+// temperatureLabels returns the labels of a temperature metric, adding the
+// unit label when exporting both units.
+func (c *eCollector) temperatureLabels(labels ...string) []string {
+	if c.temperatureUnit == BothUnits {
+		return append(labels[:len(labels):len(labels)], "unit")
+	}
+	return labels
+}
+
+// collectTemperature emits a temperature in degrees Fahrenheit in the
+// configured unit.
+func (c *eCollector) collectTemperature(ch chan<- prometheus.Metric, desc *prometheus.Desc, f float64, labels ...string) {
+	c.collectUnits(ch, desc, f, (f-32)*5/9, labels)
+}
+
+// collectTemperatureDifference emits a difference between temperatures in
+// degrees Fahrenheit in the configured unit.
+func (c *eCollector) collectTemperatureDifference(ch chan<- prometheus.Metric, desc *prometheus.Desc, f float64, labels ...string) {
+	c.collectUnits(ch, desc, f, f*5/9, labels)
+}
+
+// collectUnits emits the Fahrenheit or Celsius value of desc, as configured,
+// or both of them with their unit label.
+func (c *eCollector) collectUnits(ch chan<- prometheus.Metric, desc *prometheus.Desc, f, celsius float64, labels []string) {
+	switch c.temperatureUnit {
+	case BothUnits:
+		// the variadic labels may have spare capacity, don't share it
+		labels = labels[:len(labels):len(labels)]
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, f, append(labels, "F")...)
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, celsius, append(labels, "C")...)
+	case Celsius:
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, celsius, labels...)
+	default:
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, f, labels...)
+	}
+}
+
+// activeClimate returns the climate currently in effect on t: the one a
+// running event holds it at, or the one its program schedules.
+func activeClimate(t Thermostat) (Climate, bool) {
+	ref := t.Program.CurrentClimate
+	for _, e := range t.Program.Events {
+		if e.ClimateRef != "" {
+			ref = e.ClimateRef
+			break
+		}
+	}
+	for _, cl := range t.Program.Climates {
+		if cl.Ref == ref {
+			return cl, true
+		}
+	}
+	return Climate{}, false
+}
+
+// climateSensor reports whether cl takes the sensor with the given
+// identifier into account.
+func climateSensor(cl Climate, id string) bool {
+	for _, s := range cl.Sensors {
+		if s == id {
+			return true
+		}
+	}
+	return false
+}
+
+// sensorOnline reports whether a sensor currently reports any readings.
+func sensorOnline(s ecobee.RemoteSensor) bool {
+	for _, sc := range s.Capability {
+		if capabilityReported(sc) {
+			return true
+		}
+	}
+	return false
+}
+
+// capabilityReported reports whether a sensor capability has a reading.
+// Sensors that lost contact with the thermostat report "unknown" instead,
+// and some onboard sensors report capabilities they have no value for.
+func capabilityReported(sc ecobee.RemoteSensorCapability) bool {
+	return sc.Value != "" && sc.Value != "unknown"
+}
+
+// parseOccupancy parses a sensor occupancy value, accepting anything
+// strconv.ParseBool does regardless of case, as well as yes/no and on/off.
+func parseOccupancy(value string) (bool, error) {
+	switch v := strings.ToLower(strings.TrimSpace(value)); v {
+	case "yes", "on":
+		return true, nil
+	case "no", "off":
+		return false, nil
+	default:
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b, nil
+		}
+	}
+	return false, fmt.Errorf("unknown sensor occupancy value %q", value)
+}
+
+// boolToFloat converts a boolean into the 0 or 1 gauge value used for states.
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
 	}
+	return 0
 }
@@ -0,0 +1,522 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/billykwooten/go-ecobee/ecobee"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeClient returns canned Ecobee API responses.
+type fakeClient struct {
+	thermostats    []ecobee.Thermostat
+	thermostatsErr error
+	summary        map[string]ecobee.ThermostatSummary
+	summaryErr     error
+
+	// if set, every call waits for the other one to start
+	barrier *sync.WaitGroup
+}
+
+func (f *fakeClient) GetThermostats(ecobee.Selection) ([]ecobee.Thermostat, error) {
+	f.wait()
+	return f.thermostats, f.thermostatsErr
+}
+
+func (f *fakeClient) GetThermostatSummary(ecobee.Selection) (map[string]ecobee.ThermostatSummary, error) {
+	f.wait()
+	return f.summary, f.summaryErr
+}
+
+func (f *fakeClient) wait() {
+	if f.barrier != nil {
+		f.barrier.Done()
+		f.barrier.Wait()
+	}
+}
+
+func newTestCollector(t *testing.T, client Client, opts ...Option) *eCollector {
+	t.Helper()
+	c, err := NewEcobeeCollector(client, "ecobee", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestNewEcobeeCollectorPrefix(t *testing.T) {
+	for _, prefix := range []string{"ecobee", "home_ecobee", "_ecobee", "ecobee:"} {
+		if _, err := NewEcobeeCollector(nil, prefix); err != nil {
+			t.Errorf("prefix %q: %v", prefix, err)
+		}
+	}
+	for _, prefix := range []string{"", "eco-bee", "my ecobee", "1ecobee", "ecobée"} {
+		if _, err := NewEcobeeCollector(nil, prefix); err == nil {
+			t.Errorf("prefix %q: expected an error", prefix)
+		}
+	}
+}
+
+func TestUnitSuffixesBothUnits(t *testing.T) {
+	if _, err := NewEcobeeCollector(nil, "ecobee", WithTemperatureUnit(BothUnits), WithUnitSuffixes()); err == nil {
+		t.Error("got no error combining unit suffixes with both units")
+	}
+}
+
+func TestWithoutSensorLabelsIdentifiers(t *testing.T) {
+	if _, err := NewEcobeeCollector(nil, "ecobee", WithoutSensorLabels("sensor_id")); err == nil {
+		t.Error("expected an error dropping sensor_id")
+	}
+}
+
+func testThermostat() ecobee.Thermostat {
+	return ecobee.Thermostat{
+		Identifier:  "123",
+		Name:        "Home",
+		ModelNumber: "nikeSmart",
+		Brand:       "ecobee",
+		// thermostats report both times in every response
+		ThermostatTime: "2026-01-15 13:35:02",
+		UtcTime:        "2026-01-15 18:35:00",
+		Settings:       ecobee.Settings{HvacMode: "heat"},
+		Runtime: ecobee.Runtime{
+			Connected:         true,
+			ActualTemperature: 705,
+			ActualHumidity:    40,
+			DesiredHeat:       690,
+			DesiredCool:       760,
+			DesiredFanMode:    "auto",
+			DesiredHeatRange:  []int{450, 790},
+			DesiredCoolRange:  []int{650, 920},
+		},
+	}
+}
+
+func TestCollect(t *testing.T) {
+	disconnected := testThermostat()
+	disconnected.Runtime.Connected = false
+
+	withoutSettings := testThermostat()
+	withoutSettings.Settings = ecobee.Settings{}
+
+	withWeather := testThermostat()
+	withWeather.Weather.Forecasts = []ecobee.WeatherForecast{{Temperature: 352, Pressure: 1013, WindSpeed: 12000}}
+
+	withSensors := testThermostat()
+	withSensors.RemoteSensors = []ecobee.RemoteSensor{
+		{
+			ID:         "ei:0",
+			Name:       "Home",
+			Type:       "thermostat",
+			InUse:      true,
+			Capability: []ecobee.RemoteSensorCapability{{ID: "1", Type: "temperature", Value: "705"}},
+		},
+		{
+			ID:         "rs:100",
+			Name:       "Bedroom",
+			Type:       "ecobee3_remote_sensor",
+			Capability: []ecobee.RemoteSensorCapability{{ID: "1", Type: "temperature", Value: "680"}},
+		},
+	}
+
+	withClimates := withSensors
+	withClimates.Program = ecobee.Program{
+		CurrentClimateRef: "sleep",
+		Climates: []ecobee.Climate{
+			{ClimateRef: "home", Sensors: []ecobee.RemoteSensor{{ID: "ei:0:1"}, {ID: "rs:100:1"}}},
+			{ClimateRef: "sleep", Sensors: []ecobee.RemoteSensor{{ID: "rs:100:1"}}},
+		},
+	}
+
+	withHold := testThermostat()
+	withHold.Events = []ecobee.Event{
+		{Type: "hold", Running: true, HoldClimateRef: "away", Fan: "auto"},
+		{Type: "vacation", Running: false, Fan: "on"},
+	}
+
+	tests := []struct {
+		name    string
+		client  *fakeClient
+		opts    []Option
+		metrics []string
+		want    string
+	}{
+		{
+			name:    "runtime",
+			client:  &fakeClient{thermostats: []ecobee.Thermostat{testThermostat()}},
+			metrics: []string{"ecobee_actual_temperature", "ecobee_target_temperature_min", "ecobee_target_temperature_max"},
+			want: `
+# HELP ecobee_actual_temperature current temperature averaged by the thermostat across the sensors in use in degrees Fahrenheit
+# TYPE ecobee_actual_temperature gauge
+ecobee_actual_temperature{thermostat_id="123",thermostat_name="Home"} 70.5
+# HELP ecobee_target_temperature_max maximum temperature for thermostat to maintain in degrees Fahrenheit
+# TYPE ecobee_target_temperature_max gauge
+ecobee_target_temperature_max{thermostat_id="123",thermostat_name="Home"} 76
+# HELP ecobee_target_temperature_min minimum temperature for thermostat to maintain in degrees Fahrenheit
+# TYPE ecobee_target_temperature_min gauge
+ecobee_target_temperature_min{thermostat_id="123",thermostat_name="Home"} 69
+`,
+		},
+		{
+			name:    "celsius",
+			client:  &fakeClient{thermostats: []ecobee.Thermostat{testThermostat()}},
+			opts:    []Option{WithTemperatureUnit(Celsius)},
+			metrics: []string{"ecobee_target_temperature_min"},
+			want: `
+# HELP ecobee_target_temperature_min minimum temperature for thermostat to maintain in degrees Celsius
+# TYPE ecobee_target_temperature_min gauge
+ecobee_target_temperature_min{thermostat_id="123",thermostat_name="Home"} 20.555555555555557
+`,
+		},
+		{
+			name:    "both units",
+			client:  &fakeClient{thermostats: []ecobee.Thermostat{withSensors}},
+			opts:    []Option{WithTemperatureUnit(BothUnits)},
+			metrics: []string{"ecobee_target_temperature_deadband", "ecobee_temperature"},
+			want: `
+# HELP ecobee_target_temperature_deadband difference between the maximum and minimum temperature for thermostat to maintain in the degrees given by the unit label
+# TYPE ecobee_target_temperature_deadband gauge
+ecobee_target_temperature_deadband{thermostat_id="123",thermostat_name="Home",unit="C"} 3.888888888888889
+ecobee_target_temperature_deadband{thermostat_id="123",thermostat_name="Home",unit="F"} 7
+# HELP ecobee_temperature temperature reported by a sensor in the degrees given by the unit label
+# TYPE ecobee_temperature gauge
+ecobee_temperature{sensor_id="ei:0",sensor_name="Home",sensor_type="thermostat",thermostat_id="123",thermostat_name="Home",unit="C"} 21.38888888888889
+ecobee_temperature{sensor_id="ei:0",sensor_name="Home",sensor_type="thermostat",thermostat_id="123",thermostat_name="Home",unit="F"} 70.5
+ecobee_temperature{sensor_id="rs:100",sensor_name="Bedroom",sensor_type="ecobee3_remote_sensor",thermostat_id="123",thermostat_name="Home",unit="C"} 20
+ecobee_temperature{sensor_id="rs:100",sensor_name="Bedroom",sensor_type="ecobee3_remote_sensor",thermostat_id="123",thermostat_name="Home",unit="F"} 68
+`,
+		},
+		{
+			name:    "sensor types",
+			client:  &fakeClient{thermostats: []ecobee.Thermostat{withSensors}},
+			metrics: []string{"ecobee_in_use", "ecobee_sensor_info"},
+			want: `
+# HELP ecobee_in_use is sensor being used in thermostat calculations (0 or 1)
+# TYPE ecobee_in_use gauge
+ecobee_in_use{sensor_id="ei:0",sensor_name="Home",sensor_type="thermostat",thermostat_id="123",thermostat_name="Home"} 1
+ecobee_in_use{sensor_id="rs:100",sensor_name="Bedroom",sensor_type="ecobee3_remote_sensor",thermostat_id="123",thermostat_name="Home"} 0
+`,
+		},
+		{
+			name:    "without onboard sensor",
+			client:  &fakeClient{thermostats: []ecobee.Thermostat{withSensors}},
+			opts:    []Option{WithoutOnboardSensor()},
+			metrics: []string{"ecobee_in_use", "ecobee_onboard_temperature"},
+			want: `
+# HELP ecobee_in_use is sensor being used in thermostat calculations (0 or 1)
+# TYPE ecobee_in_use gauge
+ecobee_in_use{sensor_id="rs:100",sensor_name="Bedroom",sensor_type="ecobee3_remote_sensor",thermostat_id="123",thermostat_name="Home"} 0
+# HELP ecobee_onboard_temperature current temperature reported by the sensor built into the thermostat in degrees Fahrenheit
+# TYPE ecobee_onboard_temperature gauge
+ecobee_onboard_temperature{thermostat_id="123",thermostat_name="Home"} 70.5
+`,
+		},
+		{
+			name:    "temperature error",
+			client:  &fakeClient{thermostats: []ecobee.Thermostat{testThermostat()}},
+			metrics: []string{"ecobee_temperature_error"},
+			want: `
+# HELP ecobee_temperature_error how far the actual temperature is above (positive) or below (negative) the setpoints of the current hvac mode, 0 within them, in degrees Fahrenheit
+# TYPE ecobee_temperature_error gauge
+ecobee_temperature_error{thermostat_id="123",thermostat_name="Home"} 1.5
+`,
+		},
+		{
+			name:    "setpoint limits",
+			client:  &fakeClient{thermostats: []ecobee.Thermostat{testThermostat()}},
+			metrics: []string{"ecobee_heat_setpoint_limit_min", "ecobee_heat_setpoint_limit_max"},
+			want: `
+# HELP ecobee_heat_setpoint_limit_max highest heat setpoint the thermostat allows in degrees Fahrenheit
+# TYPE ecobee_heat_setpoint_limit_max gauge
+ecobee_heat_setpoint_limit_max{thermostat_id="123",thermostat_name="Home"} 79
+# HELP ecobee_heat_setpoint_limit_min lowest heat setpoint the thermostat allows in degrees Fahrenheit
+# TYPE ecobee_heat_setpoint_limit_min gauge
+ecobee_heat_setpoint_limit_min{thermostat_id="123",thermostat_name="Home"} 45
+`,
+		},
+		{
+			name:    "hold",
+			client:  &fakeClient{thermostats: []ecobee.Thermostat{withHold}},
+			metrics: []string{"ecobee_hold_info"},
+			want: `
+# HELP ecobee_hold_info climate a running event holds the thermostat at, empty for custom setpoints, and the fan mode it runs (always 1)
+# TYPE ecobee_hold_info gauge
+ecobee_hold_info{climate_ref="away",event_type="hold",fan="auto",thermostat_id="123",thermostat_name="Home"} 1
+`,
+		},
+		{
+			name:    "time offset",
+			client:  &fakeClient{thermostats: []ecobee.Thermostat{testThermostat()}},
+			metrics: []string{"ecobee_thermostat_time_offset_seconds"},
+			want: `
+# HELP ecobee_thermostat_time_offset_seconds how far the local time of the thermostat is ahead of UTC, as configured by its time zone
+# TYPE ecobee_thermostat_time_offset_seconds gauge
+ecobee_thermostat_time_offset_seconds{thermostat_id="123",thermostat_name="Home"} -18000
+`,
+		},
+		{
+			name:    "sensors in active climate",
+			client:  &fakeClient{thermostats: []ecobee.Thermostat{withClimates}},
+			metrics: []string{"ecobee_sensor_in_active_climate"},
+			want: `
+# HELP ecobee_sensor_in_active_climate is sensor taken into account by the climate (comfort setting) currently in effect (0 or 1)
+# TYPE ecobee_sensor_in_active_climate gauge
+ecobee_sensor_in_active_climate{sensor_id="ei:0",sensor_name="Home",sensor_type="thermostat",thermostat_id="123",thermostat_name="Home"} 0
+ecobee_sensor_in_active_climate{sensor_id="rs:100",sensor_name="Bedroom",sensor_type="ecobee3_remote_sensor",thermostat_id="123",thermostat_name="Home"} 1
+`,
+		},
+		{
+			name:    "unit suffixes",
+			client:  &fakeClient{thermostats: []ecobee.Thermostat{withWeather}},
+			opts:    []Option{WithTemperatureUnit(Celsius), WithUnitSuffixes()},
+			metrics: []string{"ecobee_target_temperature_min_celsius", "ecobee_thermostat_humidity_percent", "ecobee_weather_wind_speed_mph", "ecobee_weather_pressure_millibars"},
+			want: `
+# HELP ecobee_target_temperature_min_celsius minimum temperature for thermostat to maintain in degrees Celsius
+# TYPE ecobee_target_temperature_min_celsius gauge
+ecobee_target_temperature_min_celsius{thermostat_id="123",thermostat_name="Home"} 20.555555555555557
+# HELP ecobee_thermostat_humidity_percent humidity reported by the thermostat in percent
+# TYPE ecobee_thermostat_humidity_percent gauge
+ecobee_thermostat_humidity_percent{thermostat_id="123",thermostat_name="Home"} 40
+# HELP ecobee_weather_pressure_millibars current barometric pressure at the thermostat location in millibars
+# TYPE ecobee_weather_pressure_millibars gauge
+ecobee_weather_pressure_millibars{thermostat_id="123",thermostat_name="Home"} 1013
+# HELP ecobee_weather_wind_speed_mph current wind speed at the thermostat location in mph
+# TYPE ecobee_weather_wind_speed_mph gauge
+ecobee_weather_wind_speed_mph{thermostat_id="123",thermostat_name="Home"} 12
+`,
+		},
+		{
+			name:    "hvac mode",
+			client:  &fakeClient{thermostats: []ecobee.Thermostat{testThermostat()}},
+			metrics: []string{"ecobee_currenthvacmode"},
+			want: `
+# HELP ecobee_currenthvacmode current hvac mode of thermostat
+# TYPE ecobee_currenthvacmode gauge
+ecobee_currenthvacmode{current_hvac_mode="heat",thermostat_id="123",thermostat_name="Home"} 0
+`,
+		},
+		{
+			name:    "missing settings",
+			client:  &fakeClient{thermostats: []ecobee.Thermostat{withoutSettings}},
+			metrics: []string{"ecobee_currenthvacmode", "ecobee_hvac_mode"},
+			want:    "",
+		},
+		{
+			name:    "dropped sensor labels",
+			client:  &fakeClient{thermostats: []ecobee.Thermostat{withSensors}},
+			opts:    []Option{WithoutSensorLabels("thermostat_name", "sensor_name")},
+			metrics: []string{"ecobee_in_use", "ecobee_sensor_info"},
+			want: `
+# HELP ecobee_sensor_info sensor names and types, for sensor metrics with dropped labels (always 1)
+# TYPE ecobee_sensor_info gauge
+ecobee_sensor_info{sensor_id="ei:0",sensor_name="Home",sensor_type="thermostat",thermostat_id="123",thermostat_name="Home"} 1
+ecobee_sensor_info{sensor_id="rs:100",sensor_name="Bedroom",sensor_type="ecobee3_remote_sensor",thermostat_id="123",thermostat_name="Home"} 1
+# HELP ecobee_in_use is sensor being used in thermostat calculations (0 or 1)
+# TYPE ecobee_in_use gauge
+ecobee_in_use{sensor_id="ei:0",sensor_type="thermostat",thermostat_id="123"} 1
+ecobee_in_use{sensor_id="rs:100",sensor_type="ecobee3_remote_sensor",thermostat_id="123"} 0
+`,
+		},
+		{
+			name:    "disconnected",
+			client:  &fakeClient{thermostats: []ecobee.Thermostat{disconnected}},
+			metrics: []string{"ecobee_thermostat_connected", "ecobee_actual_temperature"},
+			want: `
+# HELP ecobee_thermostat_connected is thermostat connected to the Ecobee servers (0 or 1)
+# TYPE ecobee_thermostat_connected gauge
+ecobee_thermostat_connected{thermostat_id="123",thermostat_name="Home"} 0
+`,
+		},
+		{
+			name: "equipment",
+			client: &fakeClient{summary: map[string]ecobee.ThermostatSummary{
+				"123": {
+					Identifier:      "123",
+					Name:            "Home",
+					Connected:       true,
+					EquipmentStatus: ecobee.EquipmentStatus{Fan: true, AuxHeat2: true},
+				},
+			}},
+			metrics: []string{"ecobee_mode"},
+			want: `
+# HELP ecobee_mode is hvac equipment currently running (0 or 1)
+# TYPE ecobee_mode gauge
+ecobee_mode{mode="aux",thermostat_id="123",thermostat_name="Home"} 0
+ecobee_mode{mode="aux1",thermostat_id="123",thermostat_name="Home"} 0
+ecobee_mode{mode="aux2",thermostat_id="123",thermostat_name="Home"} 1
+ecobee_mode{mode="aux3",thermostat_id="123",thermostat_name="Home"} 0
+ecobee_mode{mode="cool",thermostat_id="123",thermostat_name="Home"} 0
+ecobee_mode{mode="cool2",thermostat_id="123",thermostat_name="Home"} 0
+ecobee_mode{mode="dehumidifier",thermostat_id="123",thermostat_name="Home"} 0
+ecobee_mode{mode="economizer",thermostat_id="123",thermostat_name="Home"} 0
+ecobee_mode{mode="fan",thermostat_id="123",thermostat_name="Home"} 1
+ecobee_mode{mode="heat",thermostat_id="123",thermostat_name="Home"} 0
+ecobee_mode{mode="heat2",thermostat_id="123",thermostat_name="Home"} 0
+ecobee_mode{mode="humidifier",thermostat_id="123",thermostat_name="Home"} 0
+ecobee_mode{mode="ventilator",thermostat_id="123",thermostat_name="Home"} 0
+`,
+		},
+		{
+			name: "missing from summary",
+			client: &fakeClient{
+				thermostats: []ecobee.Thermostat{testThermostat()},
+				summary:     map[string]ecobee.ThermostatSummary{},
+			},
+			metrics: []string{"ecobee_mode", "ecobee_equipment_running", "ecobee_thermostat_connected"},
+			want: `
+# HELP ecobee_thermostat_connected is thermostat connected to the Ecobee servers (0 or 1)
+# TYPE ecobee_thermostat_connected gauge
+ecobee_thermostat_connected{thermostat_id="123",thermostat_name="Home"} 1
+`,
+		},
+		{
+			name:    "api error",
+			client:  &fakeClient{thermostatsErr: errors.New("api error 3: processing error")},
+			metrics: []string{"ecobee_up", "ecobee_thermostat_connected"},
+			want: `
+# HELP ecobee_up was the last Ecobee API call successful (0 or 1)
+# TYPE ecobee_up gauge
+ecobee_up{call="get_thermostat_summary"} 1
+ecobee_up{call="get_thermostats"} 0
+`,
+		},
+		{
+			name:    "authorization revoked",
+			client:  &fakeClient{thermostatsErr: errors.New(`error on get request: Get "https://api.ecobee.com/1/thermostat": error refreshing token: invalid server response: 401 Unauthorized`)},
+			metrics: []string{"ecobee_authorization_revoked"},
+			want: `
+# HELP ecobee_authorization_revoked did the latest Ecobee API calls fail because the authorization of the exporter is missing, expired or revoked (0 or 1)
+# TYPE ecobee_authorization_revoked gauge
+ecobee_authorization_revoked 1
+`,
+		},
+		{
+			name:    "disabled group",
+			client:  &fakeClient{thermostats: []ecobee.Thermostat{testThermostat()}},
+			opts:    []Option{WithoutMetricGroups(RuntimeMetrics)},
+			metrics: []string{"ecobee_actual_temperature"},
+			want:    "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCollector(t, tt.client, tt.opts...)
+			if err := testutil.CollectAndCompare(c, strings.NewReader(tt.want), tt.metrics...); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestAPIRequestsCountRetries(t *testing.T) {
+	client := &fakeClient{thermostatsErr: errors.New("invalid server response: 500 Internal Server Error")}
+	c := newTestCollector(t, client, WithRetries(3, 0))
+	c.Snapshot()
+	if v := testutil.ToFloat64(c.apiRequests.WithLabelValues("thermostat")); v != 3 {
+		t.Errorf("got %v thermostat requests, want 3", v)
+	}
+	if v := testutil.ToFloat64(c.apiRequests.WithLabelValues("thermostatSummary")); v != 1 {
+		t.Errorf("got %v thermostatSummary requests, want 1", v)
+	}
+}
+
+func TestFanModeChanges(t *testing.T) {
+	th := testThermostat()
+	client := &fakeClient{thermostats: []ecobee.Thermostat{th}}
+	c := newTestCollector(t, client)
+	for _, mode := range []string{"auto", "on", "on", "auto"} {
+		client.thermostats[0].Runtime.DesiredFanMode = mode
+		testutil.CollectAndCount(c, "ecobee_fan_mode_changes_total")
+	}
+	want := `
+# HELP ecobee_fan_mode_changes_total changes of the desired fan mode of thermostat seen between scrapes
+# TYPE ecobee_fan_mode_changes_total counter
+ecobee_fan_mode_changes_total{thermostat_id="123",thermostat_name="Home"} 2
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "ecobee_fan_mode_changes_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestThermostatSelection(t *testing.T) {
+	c := newTestCollector(t, nil, WithoutMetricGroups(SensorMetrics, WeatherMetrics))
+	s := c.thermostatSelection()
+	if s.IncludeSensors || s.IncludeWeather {
+		t.Errorf("got %+v, want sensors and weather left out", s)
+	}
+	if !s.IncludeRuntime || !s.IncludeExtendedRuntime || !s.IncludeSettings || !s.IncludeProgram || !s.IncludeEvents {
+		t.Errorf("got %+v, want enabled groups included", s)
+	}
+}
+
+// loadThermostats reads thermostats from a GetThermostats API response
+// stored in testdata.
+func loadThermostats(t *testing.T, name string) []ecobee.Thermostat {
+	t.Helper()
+	b, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		ThermostatList []ecobee.Thermostat `json:"thermostatList"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.ThermostatList
+}
+
+func TestSensorFixture(t *testing.T) {
+	c := newTestCollector(t, &fakeClient{thermostats: loadThermostats(t, "sensors.json")})
+	want, err := os.Open(filepath.Join("testdata", "sensors.prom"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer want.Close()
+	metrics := []string{
+		"ecobee_temperature", "ecobee_humidity", "ecobee_occupancy", "ecobee_in_use",
+		"ecobee_sensor_capability", "ecobee_sensor_parse_errors_total", "ecobee_thermostat_sensor_online_count",
+	}
+	if err := testutil.CollectAndCompare(c, want, metrics...); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConcurrentCalls(t *testing.T) {
+	barrier := &sync.WaitGroup{}
+	barrier.Add(2)
+	client := &fakeClient{thermostats: []ecobee.Thermostat{testThermostat()}, barrier: barrier}
+	// both calls only return once the other one started
+	c := newTestCollector(t, client, WithConcurrency(2), WithTimeout(5*time.Second))
+	if _, err := c.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCachedScrapeParsesOnce(t *testing.T) {
+	th := testThermostat()
+	th.RemoteSensors = []ecobee.RemoteSensor{{
+		ID:         "rs:100",
+		Name:       "Bedroom",
+		Type:       "ecobee3_remote_sensor",
+		Capability: []ecobee.RemoteSensorCapability{{ID: "1", Type: "temperature", Value: "warm"}},
+	}}
+	c := newTestCollector(t, &fakeClient{thermostats: []ecobee.Thermostat{th}}, WithCacheTTL(time.Hour))
+	for i := 0; i < 2; i++ {
+		testutil.CollectAndCount(c)
+	}
+	if v := testutil.ToFloat64(c.scrapeErrors.WithLabelValues("parse_temperature")); v != 1 {
+		t.Errorf("got %v parse_temperature errors, want 1", v)
+	}
+	if v := testutil.ToFloat64(c.sensorParseErrors.WithLabelValues("temperature")); v != 1 {
+		t.Errorf("got %v temperature parse errors, want 1", v)
+	}
+}
@@ -0,0 +1,77 @@
+package collector
+
+import (
+	"sync"
+	"time"
+)
+
+// equipmentCycles tracks when every piece of equipment of every thermostat
+// last turned on and how often it did, from the equipment status seen across
+// scrapes. Cycles are only as precise as the scrape interval: equipment is
+// assumed to have turned on when it's first seen running, and cycles shorter
+// than the scrape interval go unnoticed.
+type equipmentCycles struct {
+	mu sync.Mutex
+
+	// start of the current cycle of running equipment, per thermostat
+	since map[string]map[string]time.Time
+
+	// cycles started since the exporter started, per thermostat and
+	// equipment; equipment already running when first seen isn't counted
+	count map[string]map[string]float64
+}
+
+func newEquipmentCycles() *equipmentCycles {
+	return &equipmentCycles{
+		since: map[string]map[string]time.Time{},
+		count: map[string]map[string]float64{},
+	}
+}
+
+// update records the equipment status of the thermostat with the given
+// identifier as of at and returns how long every piece of equipment has been
+// running in its current cycle, 0 for equipment that is off, along with the
+// number of cycles every piece of equipment started.
+func (e *equipmentCycles) update(id string, running map[string]bool, at time.Time) (seconds, cycles map[string]float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	since, ok := e.since[id]
+	if !ok {
+		since = map[string]time.Time{}
+		e.since[id] = since
+	}
+	count, seen := e.count[id]
+	if !seen {
+		count = map[string]float64{}
+		e.count[id] = count
+	}
+
+	seconds = make(map[string]float64, len(running))
+	for equipment, on := range running {
+		if _, ok := count[equipment]; !ok {
+			count[equipment] = 0
+		}
+		if !on {
+			delete(since, equipment)
+			seconds[equipment] = 0
+			continue
+		}
+		start, ok := since[equipment]
+		if !ok {
+			// turned on since the last scrape, unless seen for the first time
+			if seen {
+				count[equipment]++
+			}
+			start = at
+			since[equipment] = start
+		}
+		seconds[equipment] = at.Sub(start).Seconds()
+	}
+
+	cycles = make(map[string]float64, len(count))
+	for equipment, v := range count {
+		cycles[equipment] = v
+	}
+	return seconds, cycles
+}
@@ -0,0 +1,19 @@
+package collector
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEquipmentCycles(t *testing.T) {
+	e := newEquipmentCycles()
+	at := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	var cycles map[string]float64
+	// already running when first seen, then two more cycles
+	for i, on := range []bool{true, false, true, true, false, true} {
+		_, cycles = e.update("1", map[string]bool{"compCool1": on}, at.Add(time.Duration(i)*time.Minute))
+	}
+	if cycles["compCool1"] != 2 {
+		t.Errorf("got %v cycles, want 2", cycles["compCool1"])
+	}
+}
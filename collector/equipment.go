@@ -0,0 +1,24 @@
+package collector
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/billykwooten/go-ecobee/ecobee"
+)
+
+// equipmentStates returns whether each piece of equipment in es is running,
+// keyed by the name the API uses for it (e.g. compCool1). Fields are found
+// via reflection so equipment added to go-ecobee is picked up automatically.
+func equipmentStates(es ecobee.EquipmentStatus) map[string]bool {
+	v := reflect.ValueOf(es)
+	states := make(map[string]bool, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.Type.Kind() != reflect.Bool {
+			continue
+		}
+		states[strings.ToLower(f.Name[:1])+f.Name[1:]] = v.Field(i).Bool()
+	}
+	return states
+}
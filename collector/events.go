@@ -0,0 +1,47 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/billykwooten/go-ecobee/ecobee"
+)
+
+// thermostatOffset returns how far the local time of the thermostat is
+// ahead of UTC, rounded to the minute as both times are only sampled close
+// to each other.
+func thermostatOffset(t ecobee.Thermostat) (time.Duration, error) {
+	local, err := time.Parse(ecobeeTimeLayout, t.ThermostatTime)
+	if err != nil {
+		return 0, err
+	}
+	utc, err := time.Parse(ecobeeTimeLayout, t.UtcTime)
+	if err != nil {
+		return 0, err
+	}
+	return local.Sub(utc).Round(time.Minute), nil
+}
+
+// eventEnd returns the end of an event, which the API reports in the local
+// time of the thermostat.
+func eventEnd(e ecobee.Event, offset time.Duration) (time.Time, error) {
+	end, err := time.Parse(ecobeeTimeLayout, e.EndDate+" "+e.EndTime)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return end.Add(-offset), nil
+}
+
+// runningEvents returns the running events of t, keeping only the first
+// (highest priority) one of every type.
+func runningEvents(t ecobee.Thermostat) []ecobee.Event {
+	var events []ecobee.Event
+	seen := map[string]bool{}
+	for _, e := range t.Events {
+		if !e.Running || seen[e.Type] {
+			continue
+		}
+		seen[e.Type] = true
+		events = append(events, e)
+	}
+	return events
+}
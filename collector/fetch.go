@@ -0,0 +1,269 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/billykwooten/go-ecobee/ecobee"
+)
+
+// fetchResult holds the data returned by one round of Ecobee API calls.
+type fetchResult struct {
+	thermostats        []ecobee.Thermostat
+	thermostatsErr     error
+	thermostatsElapsed time.Duration
+
+	summary        map[string]ecobee.ThermostatSummary
+	summaryErr     error
+	summaryElapsed time.Duration
+
+	fetchedAt time.Time
+
+	// the data above parsed once, so cached and shared results don't count
+	// parse errors again
+	metrics Metrics
+}
+
+// fetchCache keeps the last successful fetchResult around.
+type fetchCache struct {
+	mu     sync.Mutex
+	result *fetchResult
+}
+
+// cachedFetch returns the last successful fetchResult if it is younger than
+// the configured cache TTL, and fetches fresh data otherwise.
+func (c *eCollector) cachedFetch() fetchResult {
+	if c.cacheTTL <= 0 {
+		return c.sharedFetch()
+	}
+
+	c.cache.mu.Lock()
+	cached := c.cache.result
+	c.cache.mu.Unlock()
+	if cached != nil && time.Since(cached.fetchedAt) < c.cacheTTL {
+		return *cached
+	}
+
+	r := c.sharedFetch()
+	if r.thermostatsErr == nil && r.summaryErr == nil {
+		c.cache.mu.Lock()
+		c.cache.result = &r
+		c.cache.mu.Unlock()
+	}
+	return r
+}
+
+// inflightFetch tracks the round of API calls currently in progress.
+type inflightFetch struct {
+	mu   sync.Mutex
+	call *fetchCall
+}
+
+type fetchCall struct {
+	done   chan struct{}
+	result fetchResult
+}
+
+// sharedFetch fetches fresh data, unless another scrape is already doing so,
+// in which case it waits for and returns the same result.
+func (c *eCollector) sharedFetch() fetchResult {
+	c.inflight.mu.Lock()
+	if call := c.inflight.call; call != nil {
+		c.inflight.mu.Unlock()
+		<-call.done
+		return call.result
+	}
+	call := &fetchCall{done: make(chan struct{})}
+	c.inflight.call = call
+	c.inflight.mu.Unlock()
+
+	call.result = c.fetch()
+	call.result.metrics = c.metrics(call.result)
+	c.health.set(call.result)
+
+	c.inflight.mu.Lock()
+	c.inflight.call = nil
+	c.inflight.mu.Unlock()
+	close(call.done)
+	return call.result
+}
+
+// fetch retrieves thermostats and their summary via the Ecobee API, giving
+// up once the configured timeout has passed.
+func (c *eCollector) fetch() fetchResult {
+	if c.timeout <= 0 {
+		return c.fetchThermostats()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	// go-ecobee calls don't take a context, so they're left to finish in
+	// the background; the http client timeout set up along with the
+	// collector bounds how long that takes.
+	done := make(chan fetchResult, 1)
+	go func() {
+		done <- c.fetchThermostats()
+	}()
+
+	select {
+	case r := <-done:
+		return r
+	case <-ctx.Done():
+		err := fmt.Errorf("timed out after %s fetching data via Ecobee API", c.timeout)
+		log.Error(err)
+		c.scrapeErrors.WithLabelValues("timeout").Inc()
+		return fetchResult{
+			thermostatsErr:     err,
+			thermostatsElapsed: c.timeout,
+			summaryErr:         err,
+			summaryElapsed:     c.timeout,
+			fetchedAt:          time.Now(),
+		}
+	}
+}
+
+// fetchThermostats makes the Ecobee API calls backing a scrape.
+func (c *eCollector) fetchThermostats() fetchResult {
+	r := fetchResult{fetchedAt: time.Now()}
+	var deadline time.Time
+	if c.timeout > 0 {
+		deadline = r.fetchedAt.Add(c.timeout)
+	}
+
+	c.parallel(
+		func() {
+			r.thermostatsErr = c.retry("get_thermostats", deadline, func() (err error) {
+				c.apiRequests.WithLabelValues("thermostat").Inc()
+				r.thermostats, err = c.client.GetThermostats(c.thermostatSelection())
+				return err
+			})
+			r.thermostatsElapsed = time.Now().Sub(r.fetchedAt)
+			c.fetchDuration.WithLabelValues("get_thermostats").Observe(r.thermostatsElapsed.Seconds())
+			if r.thermostatsErr != nil {
+				c.fetchError("get_thermostats", r.thermostatsErr)
+			}
+		},
+		// the summary is requested for the whole selection rather than the
+		// thermostats returned above, so it doesn't depend on the first call.
+		// Equipment status can't be folded into the first call: go-ecobee's
+		// Thermostat doesn't decode it, so it's only available from the summary.
+		func() {
+			start := time.Now()
+			r.summaryErr = c.retry("get_thermostat_summary", deadline, func() (err error) {
+				c.apiRequests.WithLabelValues("thermostatSummary").Inc()
+				r.summary, err = c.client.GetThermostatSummary(ecobee.Selection{
+					SelectionType:          c.selectionType,
+					SelectionMatch:         c.selectionMatch,
+					IncludeEquipmentStatus: true,
+				})
+				return err
+			})
+			r.summaryElapsed = time.Now().Sub(start)
+			c.fetchDuration.WithLabelValues("get_thermostat_summary").Observe(r.summaryElapsed.Seconds())
+			if r.summaryErr != nil {
+				c.fetchError("get_thermostat_summary", r.summaryErr)
+			}
+		},
+	)
+	return r
+}
+
+// parallel runs calls, at most the configured concurrency at a time, and
+// waits for all of them to return. Calls must not write to the same data.
+func (c *eCollector) parallel(calls ...func()) {
+	concurrency := c.concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, call := range calls {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(call func()) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			call()
+		}(call)
+	}
+	wg.Wait()
+}
+
+// fetchError logs and counts an error returned by call. Authorization errors
+// are counted separately, as they need the exporter to be authorized again
+// rather than waiting for the API to recover.
+func (c *eCollector) fetchError(call string, err error) {
+	log.Error(err)
+	if isAuthError(err) {
+		c.scrapeErrors.WithLabelValues("authorization").Inc()
+		return
+	}
+	c.scrapeErrors.WithLabelValues(call).Inc()
+}
+
+// thermostatSelection returns the selection of the GetThermostats call, only
+// including the data needed by the enabled metric groups to keep responses
+// small. The runtime is always needed for whether thermostats are connected.
+func (c *eCollector) thermostatSelection() ecobee.Selection {
+	return ecobee.Selection{
+		SelectionType:          c.selectionType,
+		SelectionMatch:         c.selectionMatch,
+		IncludeSensors:         c.enabled(SensorMetrics),
+		IncludeRuntime:         true,
+		IncludeExtendedRuntime: c.enabled(EquipmentMetrics),
+		IncludeSettings:        c.enabled(RuntimeMetrics),
+		IncludeProgram:         c.enabled(ProgramMetrics),
+		IncludeEvents:          c.enabled(ProgramMetrics),
+		IncludeWeather:         c.enabled(WeatherMetrics),
+	}
+}
+
+// retry calls f until it succeeds, up to the configured number of attempts,
+// doubling the delay between attempts. Authorization errors aren't retried,
+// and neither is anything that would run past a non-zero deadline.
+func (c *eCollector) retry(call string, deadline time.Time, f func() error) error {
+	delay := c.retryDelay
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= c.retryAttempts || isAuthError(err) {
+			return err
+		}
+		if !deadline.IsZero() && time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		log.Warnf("retrying %s in %s: %v", call, delay, err)
+		c.retries.WithLabelValues(call).Inc()
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// authErrors are fragments of go-ecobee errors caused by missing or rejected
+// authorization. go-ecobee only returns formatted errors, so they're matched
+// on their text. The API rejects authorization with non-200 responses, whose
+// body go-ecobee doesn't read, so their status codes are all there is.
+var authErrors = []string{
+	"401 Unauthorized",
+	"403 Forbidden",
+	"error refreshing token",
+	"error on initial authentication",
+}
+
+// isAuthError reports whether err was caused by missing or rejected
+// authorization, which won't go away by retrying.
+func isAuthError(err error) bool {
+	for _, s := range authErrors {
+		if strings.Contains(err.Error(), s) {
+			return true
+		}
+	}
+	return false
+}
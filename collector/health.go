@@ -0,0 +1,70 @@
+package collector
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// health records the outcome of the latest round of API calls.
+type health struct {
+	mu  sync.Mutex
+	err error
+
+	// time of the latest round of API calls that all succeeded
+	lastSuccess time.Time
+
+	// whether the latest round of API calls failed for lack of authorization
+	unauthorized bool
+}
+
+func (h *health) set(r fetchResult) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.err = r.thermostatsErr
+	if h.err == nil {
+		h.err = r.summaryErr
+	}
+	if h.err == nil {
+		h.lastSuccess = r.fetchedAt
+	}
+	h.unauthorized = (r.thermostatsErr != nil && isAuthError(r.thermostatsErr)) ||
+		(r.summaryErr != nil && isAuthError(r.summaryErr))
+}
+
+// lastSuccessful returns the time of the latest round of API calls that all
+// succeeded, or the zero time if there was none yet.
+func (h *health) lastSuccessful() time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lastSuccess
+}
+
+// authorizationRevoked reports whether the latest round of API calls failed
+// because the authorization of the exporter is missing, expired or revoked.
+func (h *health) authorizationRevoked() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.unauthorized
+}
+
+// Healthy reports whether the collector is able to use the Ecobee API. It
+// checks the cached token and the outcome of the latest API calls rather
+// than making a call itself, so it's cheap enough for liveness probes.
+func (c *eCollector) Healthy() error {
+	if c.tokenCacheFile != "" {
+		tok, err := readCachedToken(c.tokenCacheFile)
+		if err != nil {
+			return err
+		}
+		// the access token is refreshed lazily, so only a missing refresh
+		// token means the exporter needs to be authorized again
+		if tok.RefreshToken == "" {
+			return errors.New("no refresh token in token cache")
+		}
+	}
+
+	c.health.mu.Lock()
+	defer c.health.mu.Unlock()
+	return c.health.err
+}
@@ -0,0 +1,52 @@
+package collector
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// internalCollector exports the metrics an eCollector keeps about itself.
+type internalCollector struct {
+	c *eCollector
+}
+
+// Internal returns a collector of the metrics c keeps about itself, such as
+// errors and durations of API calls, along with the token metrics. Collecting
+// them never calls the Ecobee API, so they can be served on an endpoint that
+// stays responsive while the API is slow. They're exported by c as well.
+func (c *eCollector) Internal() prometheus.Collector {
+	return internalCollector{c}
+}
+
+func (i internalCollector) Describe(ch chan<- *prometheus.Desc) {
+	c := i.c
+	ch <- c.lastSuccess
+	ch <- c.authorizationRevoked
+	if c.tokenCacheFile != "" {
+		ch <- c.tokenExpiry
+		ch <- c.tokenValid
+	}
+	c.scrapeErrors.Describe(ch)
+	c.sensorParseErrors.Describe(ch)
+	c.retries.Describe(ch)
+	c.fetchDuration.Describe(ch)
+	c.apiRequests.Describe(ch)
+}
+
+func (i internalCollector) Collect(ch chan<- prometheus.Metric) {
+	c := i.c
+	if t := c.health.lastSuccessful(); !t.IsZero() {
+		ch <- prometheus.MustNewConstMetric(c.lastSuccess, prometheus.GaugeValue, float64(t.Unix()))
+	}
+	ch <- prometheus.MustNewConstMetric(c.authorizationRevoked, prometheus.GaugeValue, boolToFloat(c.health.authorizationRevoked()))
+	if c.tokenCacheFile != "" {
+		// errors reading the token are counted by scrapes of c already
+		tok, err := readCachedToken(c.tokenCacheFile)
+		if err == nil {
+			ch <- prometheus.MustNewConstMetric(c.tokenExpiry, prometheus.GaugeValue, float64(tok.Expiry.Unix()))
+		}
+		ch <- prometheus.MustNewConstMetric(c.tokenValid, prometheus.GaugeValue, boolToFloat(err == nil && tok.Valid()))
+	}
+	c.scrapeErrors.Collect(ch)
+	c.sensorParseErrors.Collect(ch)
+	c.retries.Collect(ch)
+	c.fetchDuration.Collect(ch)
+	c.apiRequests.Collect(ch)
+}
@@ -0,0 +1,59 @@
+package collector
+
+import (
+	"strings"
+	"unicode"
+)
+
+// NameFormat is how user provided thermostat and sensor names are turned
+// into label values.
+type NameFormat string
+
+const (
+	// RawNames uses names exactly as reported by the ecobee API.
+	RawNames NameFormat = "raw"
+	// CleanNames strips control and other non-printable characters, such as
+	// stray newlines, and surrounding whitespace.
+	CleanNames NameFormat = "clean"
+	// SlugNames lowercases names and replaces every run of characters other
+	// than letters and digits, emoji included, with a single underscore.
+	SlugNames NameFormat = "slug"
+)
+
+// format returns name in format f.
+func (f NameFormat) format(name string) string {
+	switch f {
+	case CleanNames:
+		return cleanName(name)
+	case SlugNames:
+		return slugName(name)
+	default:
+		return name
+	}
+}
+
+func cleanName(name string) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if !unicode.IsPrint(r) && r != ' ' {
+			return -1
+		}
+		return r
+	}, name))
+}
+
+func slugName(name string) string {
+	var b strings.Builder
+	sep := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if sep && b.Len() > 0 {
+				b.WriteByte('_')
+			}
+			sep = false
+			b.WriteRune(r)
+		} else {
+			sep = true
+		}
+	}
+	return b.String()
+}
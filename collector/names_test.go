@@ -0,0 +1,22 @@
+package collector
+
+import "testing"
+
+func TestNameFormat(t *testing.T) {
+	tests := []struct {
+		format     NameFormat
+		name, want string
+	}{
+		{RawNames, " Living Room\n", " Living Room\n"},
+		{CleanNames, " Living Room\n", "Living Room"},
+		{CleanNames, "Bed\u200broom", "Bedroom"},
+		{SlugNames, "Living Room", "living_room"},
+		{SlugNames, "🏠 Kid's Room (2nd floor)!", "kid_s_room_2nd_floor"},
+		{SlugNames, "Café", "café"},
+	}
+	for _, tt := range tests {
+		if got := tt.format.format(tt.name); got != tt.want {
+			t.Errorf("%s format of %q: got %q, want %q", tt.format, tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package collector
+
+import (
+	"regexp"
+	"time"
+)
+
+// Option configures optional behaviour of an eCollector.
+type Option func(*eCollector)
+
+// MetricGroup is a set of related metrics that can be disabled together.
+type MetricGroup string
+
+const (
+	// RuntimeMetrics are temperatures, setpoints and modes of a thermostat.
+	RuntimeMetrics MetricGroup = "runtime"
+	// SensorMetrics are the readings of every sensor of a thermostat.
+	SensorMetrics MetricGroup = "sensors"
+	// EquipmentMetrics are the running state and runtime of hvac equipment.
+	EquipmentMetrics MetricGroup = "equipment"
+	// ProgramMetrics are the climates and events of a thermostat program.
+	ProgramMetrics MetricGroup = "program"
+	// WeatherMetrics are the outdoor conditions at a thermostat location.
+	WeatherMetrics MetricGroup = "weather"
+)
+
+// MetricGroups lists every MetricGroup.
+var MetricGroups = []MetricGroup{RuntimeMetrics, SensorMetrics, EquipmentMetrics, ProgramMetrics, WeatherMetrics}
+
+// WithoutMetricGroups disables exporting the metrics of the given groups.
+func WithoutMetricGroups(groups ...MetricGroup) Option {
+	return func(c *eCollector) {
+		for _, g := range groups {
+			c.disabled[g] = true
+		}
+	}
+}
+
+// WithoutOnboardSensor leaves the sensor built into every thermostat out of
+// sensor metrics, as its temperature is already exported as
+// onboard_temperature. Sensor counts still include it.
+func WithoutOnboardSensor() Option {
+	return func(c *eCollector) {
+		c.skipOnboardSensor = true
+	}
+}
+
+// WithoutDisconnectedThermostats leaves thermostats that are disconnected
+// from the Ecobee servers out of all metrics, including thermostat_connected.
+// By default they're kept, with only their runtime metrics skipped.
+func WithoutDisconnectedThermostats() Option {
+	return func(c *eCollector) {
+		c.skipDisconnected = true
+	}
+}
+
+// WithoutSensorLabels drops the given labels, any of DroppableSensorLabels,
+// from sensor metrics to reduce their cardinality. sensor_info keeps all of
+// them to look names and types up by the remaining labels.
+func WithoutSensorLabels(labels ...string) Option {
+	return func(c *eCollector) {
+		c.droppedSensorLabels = append(c.droppedSensorLabels, labels...)
+	}
+}
+
+// TemperatureUnit is the unit temperature metrics are exported in.
+type TemperatureUnit string
+
+const (
+	// Fahrenheit exports temperatures as reported by the ecobee API.
+	Fahrenheit TemperatureUnit = "fahrenheit"
+	// Celsius converts temperatures to degrees Celsius.
+	Celsius TemperatureUnit = "celsius"
+	// BothUnits exports every temperature twice, with a unit label of F
+	// or C, at the cost of twice the temperature series.
+	BothUnits TemperatureUnit = "both"
+)
+
+// WithTemperatureUnit sets the unit temperature metrics are exported in.
+// The ecobee API always reports Fahrenheit, regardless of the unit the
+// thermostat displays, so this defaults to Fahrenheit.
+func WithTemperatureUnit(u TemperatureUnit) Option {
+	return func(c *eCollector) {
+		c.temperatureUnit = u
+	}
+}
+
+// WithUnitSuffixes appends units to the names of temperature, humidity, weather
+// and duration metrics lacking them, as Prometheus naming conventions suggest,
+// e.g. actual_temperature_fahrenheit or weather_pressure_millibars. Wind speed
+// and pressure are reported in mph and millibars. The client library doesn't
+// support OpenMetrics unit metadata, so units are only part of the names. It
+// can't be combined with BothUnits, whose series share a name.
+func WithUnitSuffixes() Option {
+	return func(c *eCollector) {
+		c.unitSuffixes = true
+	}
+}
+
+// WithTokenCacheFile exports the expiry and validity of the OAuth2 token
+// stored in the given go-ecobee cache file.
+func WithTokenCacheFile(path string) Option {
+	return func(c *eCollector) {
+		c.tokenCacheFile = path
+	}
+}
+
+// WithTimeout limits how long a scrape waits for the Ecobee API. Scrapes
+// that time out export the API calls as failed.
+func WithTimeout(d time.Duration) Option {
+	return func(c *eCollector) {
+		c.timeout = d
+	}
+}
+
+// WithCacheTTL reuses successful Ecobee API responses for scrapes within
+// ttl of the last fetch, to stay within the API rate limits when scraped
+// frequently.
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(c *eCollector) {
+		c.cacheTTL = ttl
+	}
+}
+
+// WithThermostatFilter limits the thermostats metrics are exported for.
+// When include is set, only thermostats whose identifier or name matches it
+// are exported; when exclude is set, thermostats whose identifier or name
+// matches it are skipped. Either can be nil.
+func WithThermostatFilter(include, exclude *regexp.Regexp) Option {
+	return func(c *eCollector) {
+		c.include = include
+		c.exclude = exclude
+	}
+}
+
+// WithRetries makes up to attempts attempts at every Ecobee API call,
+// waiting delay before the first retry and doubling it for every following
+// one. Authorization errors are never retried.
+func WithRetries(attempts int, delay time.Duration) Option {
+	return func(c *eCollector) {
+		c.retryAttempts = attempts
+		c.retryDelay = delay
+	}
+}
+
+// WithConcurrency makes up to n Ecobee API calls of a scrape at the same
+// time, rather than one after another. Every call counts against the rate
+// limits of the Ecobee API either way.
+func WithConcurrency(n int) Option {
+	return func(c *eCollector) {
+		c.concurrency = n
+	}
+}
+
+// WithSelection fetches the thermostats matching an Ecobee API selection
+// instead of the registered thermostats of the account, e.g. "thermostats"
+// with a comma separated list of identifiers, or "managementSet" with the
+// path of a management set for EMS accounts. match is ignored by the
+// "registered" selection type.
+func WithSelection(selectionType, match string) Option {
+	return func(c *eCollector) {
+		c.selectionType = selectionType
+		c.selectionMatch = match
+	}
+}
+
+// WithNameFormat sets how thermostat and sensor names are formatted in
+// label values. Thermostat filters still match the names as reported by
+// the ecobee API. Defaults to RawNames.
+func WithNameFormat(f NameFormat) Option {
+	return func(c *eCollector) {
+		c.nameFormat = f
+	}
+}
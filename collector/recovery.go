@@ -0,0 +1,65 @@
+package collector
+
+import "time"
+
+// smartRecoveryLead is how long ahead of a scheduled climate change smart
+// recovery may start to run the system, which ecobee caps at two hours.
+const smartRecoveryLead = 2 * time.Hour
+
+// scheduleSlot is the length of a single slot of a program schedule.
+const scheduleSlot = 30 * time.Minute
+
+// smartRecovery reports whether smart recovery appears to be running on t at
+// now, and whether that could be told at all. The API doesn't report smart
+// recovery, so it's derived from the desired setpoints: without an event
+// overriding the program, they only differ from the setpoints of the current
+// climate while smart recovery moves them towards the ones of the next
+// climate scheduled within the lead time.
+func smartRecovery(t Thermostat, now time.Time) (active, ok bool) {
+	if t.TimeOffset == nil || len(t.Program.Schedule) != 7 {
+		return false, false
+	}
+	climates := map[string]Climate{}
+	for _, cl := range t.Program.Climates {
+		climates[cl.Ref] = cl
+	}
+	current, ok := climates[t.Program.CurrentClimate]
+	if !ok {
+		return false, false
+	}
+	if len(t.Program.Events) > 0 {
+		return false, true
+	}
+
+	// schedules start at Monday midnight in the local time of the thermostat
+	local := now.UTC().Add(*t.TimeOffset)
+	day := (int(local.Weekday()) + 6) % 7
+	slot := local.Hour()*2 + local.Minute()/30
+	for i := 1; i <= int(smartRecoveryLead/scheduleSlot); i++ {
+		s := slot + i
+		d := (day + s/48) % 7
+		s %= 48
+		if len(t.Program.Schedule[d]) != 48 {
+			return false, false
+		}
+		next, ok := climates[t.Program.Schedule[d][s]]
+		if !ok || next.Ref == current.Ref {
+			continue
+		}
+		return recovering(t.Runtime.DesiredHeat, current.HeatSetpoint, next.HeatSetpoint) ||
+			recovering(t.Runtime.DesiredCool, current.CoolSetpoint, next.CoolSetpoint), true
+	}
+	return false, true
+}
+
+// recovering reports whether desired moved from the current setpoint towards
+// the next one.
+func recovering(desired, current, next float64) bool {
+	if desired == current || current == next {
+		return false
+	}
+	if current < next {
+		return desired > current && desired <= next
+	}
+	return desired < current && desired >= next
+}
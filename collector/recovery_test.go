@@ -0,0 +1,59 @@
+package collector
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSmartRecovery(t *testing.T) {
+	// sleep until 6:00 on weekdays, home afterwards
+	day := make([]string, 48)
+	for i := range day {
+		day[i] = "sleep"
+		if i >= 12 {
+			day[i] = "home"
+		}
+	}
+	schedule := make([][]string, 7)
+	for i := range schedule {
+		schedule[i] = day
+	}
+	offset := -5 * time.Hour
+	thermostat := func(desiredHeat float64, events ...Event) Thermostat {
+		return Thermostat{
+			TimeOffset: &offset,
+			Runtime:    Runtime{DesiredHeat: desiredHeat, DesiredCool: 78},
+			Program: Program{
+				CurrentClimate: "sleep",
+				Climates: []Climate{
+					{Ref: "sleep", HeatSetpoint: 62, CoolSetpoint: 78},
+					{Ref: "home", HeatSetpoint: 70, CoolSetpoint: 78},
+				},
+				Schedule: schedule,
+				Events:   events,
+			},
+		}
+	}
+	// Wednesday 5:00 local time
+	now := time.Date(2026, 1, 14, 10, 0, 0, 0, time.UTC)
+
+	for _, tt := range []struct {
+		name       string
+		thermostat Thermostat
+		now        time.Time
+		active, ok bool
+	}{
+		{"recovering", thermostat(65), now, true, true},
+		{"reached next setpoint", thermostat(70), now, true, true},
+		{"program setpoint", thermostat(62), now, false, true},
+		{"away from next setpoint", thermostat(60), now, false, true},
+		{"too early", thermostat(65), now.Add(-2 * time.Hour), false, true},
+		{"hold", thermostat(65, Event{Type: "hold"}), now, false, true},
+		{"unknown offset", Thermostat{Program: thermostat(65).Program}, now, false, false},
+	} {
+		active, ok := smartRecovery(tt.thermostat, tt.now)
+		if active != tt.active || ok != tt.ok {
+			t.Errorf("%s: got %v, %v, want %v, %v", tt.name, active, ok, tt.active, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/billykwooten/go-ecobee/ecobee"
+)
+
+// extendedRuntimeInterval is the length of a single extended runtime
+// reading; the API returns the three most recent ones.
+const extendedRuntimeInterval = 5 * time.Minute
+
+// ecobeeTimeLayout is the layout of timestamps returned by the ecobee API.
+const ecobeeTimeLayout = "2006-01-02 15:04:05"
+
+// runtimeTotals accumulates equipment runtime from the extended runtime
+// readings of every thermostat between scrapes, so it can be exposed as
+// monotonically growing counters, and as the runtime of the current day.
+type runtimeTotals struct {
+	mu sync.Mutex
+
+	// timestamp of the last reading accounted for, per thermostat
+	lastReading map[string]time.Time
+
+	// accumulated runtime seconds, per thermostat and equipment
+	seconds map[string]map[string]float64
+
+	// local date of the last reading and the runtime seconds accumulated
+	// on it, per thermostat and equipment
+	day   map[string]string
+	today map[string]map[string]float64
+}
+
+func newRuntimeTotals() *runtimeTotals {
+	return &runtimeTotals{
+		lastReading: map[string]time.Time{},
+		seconds:     map[string]map[string]float64{},
+		day:         map[string]string{},
+		today:       map[string]map[string]float64{},
+	}
+}
+
+// equipmentRuntime returns per-interval runtime seconds for each piece of
+// equipment, keyed with the same names the API uses for equipment status.
+func equipmentRuntime(er ecobee.ExtendedRuntime) map[string][]int {
+	return map[string][]int{
+		"heatPump":     er.HeatPump1,
+		"heatPump2":    er.HeatPump2,
+		"auxHeat1":     er.AuxHeat1,
+		"auxHeat2":     er.AuxHeat2,
+		"auxHeat3":     er.AuxHeat3,
+		"compCool1":    er.Cool1,
+		"compCool2":    er.Cool2,
+		"fan":          er.Fan,
+		"humidifier":   er.Humidifier,
+		"dehumidifier": er.Dehumidifier,
+		"economizer":   er.Economizer,
+		"ventilator":   er.Ventilator,
+	}
+}
+
+// localDate returns the date an interval ending at end starts on, in the
+// local time of a thermostat offset from UTC.
+func localDate(end time.Time, offset time.Duration) string {
+	return end.Add(offset - extendedRuntimeInterval).Format("2006-01-02")
+}
+
+// update accounts for readings of the thermostat with the given identifier
+// that were not seen before and returns the accumulated runtime seconds of its
+// equipment, in total and on the local date of the latest reading, which is
+// offset from UTC. The first time a thermostat is seen only its reading
+// timestamp is recorded, so counters start from zero rather than from
+// whatever the last 15 minutes contained.
+func (r *runtimeTotals) update(id string, er ExtendedRuntime, offset time.Duration) (totals, today map[string]float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if er.LastReading.IsZero() {
+		return nil, nil
+	}
+	reading := er.LastReading
+
+	seconds, ok := r.seconds[id]
+	if !ok {
+		seconds = map[string]float64{}
+		r.seconds[id] = seconds
+	}
+	day := localDate(reading, offset)
+	daily, ok := r.today[id]
+	if !ok || r.day[id] != day {
+		daily = map[string]float64{}
+		r.today[id] = daily
+		r.day[id] = day
+	}
+
+	last, seen := r.lastReading[id]
+	for equipment, intervals := range er.Intervals {
+		if _, ok := seconds[equipment]; !ok {
+			seconds[equipment] = 0
+		}
+		if _, ok := daily[equipment]; !ok {
+			daily[equipment] = 0
+		}
+		if !seen || !reading.After(last) {
+			continue
+		}
+		// only count intervals newer than the last accounted reading
+		n := int(reading.Sub(last) / extendedRuntimeInterval)
+		if n < 1 {
+			n = 1
+		}
+		if n > len(intervals) {
+			n = len(intervals)
+		}
+		for i, v := range intervals[len(intervals)-n:] {
+			seconds[equipment] += float64(v)
+			end := reading.Add(-time.Duration(n-1-i) * extendedRuntimeInterval)
+			if localDate(end, offset) == day {
+				daily[equipment] += float64(v)
+			}
+		}
+	}
+	if !seen || reading.After(last) {
+		r.lastReading[id] = reading
+	}
+
+	return copyRuntime(seconds), copyRuntime(daily)
+}
+
+func copyRuntime(seconds map[string]float64) map[string]float64 {
+	result := make(map[string]float64, len(seconds))
+	for equipment, v := range seconds {
+		result[equipment] = v
+	}
+	return result
+}
+
+// reportIntervals tracks how often every thermostat reports its runtime,
+// from the changes of its last modified time seen across scrapes. The API
+// only reports which interval of the day was last updated, not how long
+// intervals between reports are.
+type reportIntervals struct {
+	mu sync.Mutex
+
+	// last modified time seen and the interval before it, per thermostat
+	lastModified map[string]time.Time
+	interval     map[string]time.Duration
+}
+
+func newReportIntervals() *reportIntervals {
+	return &reportIntervals{
+		lastModified: map[string]time.Time{},
+		interval:     map[string]time.Duration{},
+	}
+}
+
+// update records the last modified time of the thermostat with the given
+// identifier and returns the latest interval between two of them, if one was
+// observed yet. Intervals are only as precise as the scrape interval, which
+// needs to be shorter than the reporting interval to observe every report.
+func (r *reportIntervals) update(id string, modified time.Time) (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	last, seen := r.lastModified[id]
+	if !seen || modified.After(last) {
+		if seen {
+			r.interval[id] = modified.Sub(last)
+		}
+		r.lastModified[id] = modified
+	}
+	interval, ok := r.interval[id]
+	return interval, ok
+}
+
+// fanModeChanges counts how often the desired fan mode of every thermostat
+// changed between scrapes.
+type fanModeChanges struct {
+	mu sync.Mutex
+
+	// last fan mode seen and the number of changes, per thermostat
+	mode    map[string]string
+	changes map[string]float64
+}
+
+func newFanModeChanges() *fanModeChanges {
+	return &fanModeChanges{
+		mode:    map[string]string{},
+		changes: map[string]float64{},
+	}
+}
+
+// update records the fan mode of the thermostat with the given identifier
+// and returns the number of changes seen so far. Changes back and forth
+// between two scrapes go unnoticed.
+func (f *fanModeChanges) update(id, mode string) float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if last, seen := f.mode[id]; seen && last != mode {
+		f.changes[id]++
+	}
+	f.mode[id] = mode
+	return f.changes[id]
+}
@@ -0,0 +1,24 @@
+package collector
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRuntimeToday(t *testing.T) {
+	r := newRuntimeTotals()
+	offset := -5 * time.Hour
+	// readings end 23:55 and 00:05 local time
+	first := time.Date(2026, 1, 15, 4, 55, 0, 0, time.UTC)
+	second := first.Add(2 * extendedRuntimeInterval)
+
+	r.update("1", ExtendedRuntime{LastReading: first, Intervals: map[string][]int{"fan": {300, 300, 300}}}, offset)
+	totals, today := r.update("1", ExtendedRuntime{LastReading: second, Intervals: map[string][]int{"fan": {300, 200, 100}}}, offset)
+	if totals["fan"] != 300 {
+		t.Errorf("got %v total seconds, want 300", totals["fan"])
+	}
+	// the 23:55 to 00:00 interval counts towards the previous day
+	if today["fan"] != 100 {
+		t.Errorf("got %v seconds today, want 100", today["fan"])
+	}
+}
@@ -0,0 +1,38 @@
+package collector
+
+import (
+	"sync"
+	"time"
+)
+
+// sensorsSeen tracks when every sensor last reported any readings, across
+// scrapes.
+type sensorsSeen struct {
+	mu sync.Mutex
+
+	// fetch time of the last readings, per thermostat and sensor
+	lastSeen map[string]map[string]time.Time
+}
+
+func newSensorsSeen() *sensorsSeen {
+	return &sensorsSeen{lastSeen: map[string]map[string]time.Time{}}
+}
+
+// update records whether the sensor with the given identifier of a thermostat
+// reported readings fetched at at, and returns the last time it did, if it
+// was ever seen online.
+func (s *sensorsSeen) update(thermostatID, sensorID string, online bool, at time.Time) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sensors, ok := s.lastSeen[thermostatID]
+	if !ok {
+		sensors = map[string]time.Time{}
+		s.lastSeen[thermostatID] = sensors
+	}
+	if online {
+		sensors[sensorID] = at
+	}
+	last, ok := sensors[sensorID]
+	return last, ok
+}
@@ -0,0 +1,20 @@
+package collector
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSensorsSeen(t *testing.T) {
+	s := newSensorsSeen()
+	at := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	if _, ok := s.update("1", "rs:100", false, at); ok {
+		t.Error("sensor never seen online has a last seen time")
+	}
+	s.update("1", "rs:100", true, at.Add(time.Minute))
+	// readings turn "unknown" once the sensor stops reporting
+	last, ok := s.update("1", "rs:100", false, at.Add(2*time.Minute))
+	if !ok || !last.Equal(at.Add(time.Minute)) {
+		t.Errorf("got %v, %v, want %v", last, ok, at.Add(time.Minute))
+	}
+}
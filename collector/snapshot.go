@@ -0,0 +1,490 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/billykwooten/go-ecobee/ecobee"
+)
+
+// Metrics is a snapshot of the thermostats of an account, parsed from one
+// round of Ecobee API calls. Temperatures are in degrees Fahrenheit, as
+// reported by the API, regardless of the configured unit.
+type Metrics struct {
+	// time the API calls were made
+	FetchedAt time.Time
+
+	// outcome of every API call
+	Calls []Call
+
+	// thermostats returned by GetThermostats
+	Thermostats []Thermostat
+
+	// equipment status returned by GetThermostatSummary
+	Equipment []EquipmentStatus
+}
+
+// Call is the outcome of a single Ecobee API call.
+type Call struct {
+	Name    string
+	Elapsed time.Duration
+	Err     error
+}
+
+// MarshalJSON encodes the call with its error as a string, null if the call
+// succeeded.
+func (c Call) MarshalJSON() ([]byte, error) {
+	var err *string
+	if c.Err != nil {
+		msg := c.Err.Error()
+		err = &msg
+	}
+	return json.Marshal(struct {
+		Name    string
+		Elapsed time.Duration
+		Err     *string
+	}{c.Name, c.Elapsed, err})
+}
+
+// Thermostat holds the data of a single thermostat.
+type Thermostat struct {
+	ID, Name        string
+	Model, Brand    string
+	Connected       bool
+	HvacMode        string
+	Runtime         Runtime
+	ExtendedRuntime ExtendedRuntime
+	Program         Program
+	Sensors         []Sensor
+
+	// time the thermostat last updated its runtime, zero if unknown
+	LastModified time.Time
+
+	// how far the local time of the thermostat is ahead of UTC, if known
+	TimeOffset *time.Duration
+
+	// current weather at the location of the thermostat, if known
+	Weather *Weather
+}
+
+// Runtime holds the current state of a thermostat.
+type Runtime struct {
+	ActualTemperature float64
+	DesiredHeat       float64
+	DesiredCool       float64
+	ActualHumidity    float64
+	DesiredHumidity   float64
+	DesiredDehumidity float64
+	DesiredFanMode    string
+
+	// lowest and highest setpoints the thermostat currently allows, nil if
+	// unknown
+	HeatRange, CoolRange []float64
+}
+
+// ExtendedRuntime holds the latest runtime readings of a thermostat.
+type ExtendedRuntime struct {
+	// end of the latest reading, zero if there is none
+	LastReading time.Time
+
+	// runtime seconds of every reading, oldest first, by equipment
+	Intervals map[string][]int
+}
+
+// Program holds the program of a thermostat and the events overriding it.
+type Program struct {
+	CurrentClimate string
+	Climates       []Climate
+
+	// climate refs scheduled for every half hour of every day of the week,
+	// starting at Monday midnight
+	Schedule [][]string
+
+	// stable hash of the schedule and climates, changes when reprogrammed
+	Hash string
+
+	// the first running event of every type
+	Events []Event
+}
+
+// Climate is a single climate of a thermostat program.
+type Climate struct {
+	Ref, Name    string
+	HeatSetpoint float64
+	CoolSetpoint float64
+
+	// identifiers of the sensors the climate takes into account
+	Sensors []string
+}
+
+// Event is a running event, such as a hold or a vacation.
+type Event struct {
+	Type string
+
+	// climate the event holds the thermostat at, empty for custom setpoints
+	ClimateRef string
+
+	// fan mode the event runs the fan in
+	Fan string
+
+	// end of the event, zero if unknown
+	End time.Time
+}
+
+// Weather holds the current weather conditions.
+type Weather struct {
+	Temperature float64
+	Humidity    float64
+
+	// wind speed in miles per hour
+	WindSpeed float64
+
+	// pressure in millibars
+	Pressure float64
+}
+
+// Sensor holds the readings of a sensor. Readings the sensor doesn't have or
+// that couldn't be parsed are nil.
+type Sensor struct {
+	ID, Name, Type string
+	InUse          bool
+	Online         bool
+	Temperature    *float64
+	Humidity       *float64
+	Occupied       *bool
+	AirQuality     *float64
+	CO2            *float64
+	VOC            *float64
+
+	// numeric capabilities without a dedicated field, by type
+	Capabilities map[string]float64
+}
+
+// EquipmentStatus holds which equipment a thermostat is currently running.
+type EquipmentStatus struct {
+	ThermostatID, ThermostatName string
+
+	// whether every piece of equipment is running, by name
+	Running map[string]bool
+}
+
+// Snapshot fetches the thermostats of the account client is authorized for.
+// Metrics are returned even if err is not nil, with whatever data the API
+// calls that succeeded returned.
+func Snapshot(client Client) (Metrics, error) {
+	c, err := NewEcobeeCollector(client, "ecobee")
+	if err != nil {
+		return Metrics{}, err
+	}
+	return c.Snapshot()
+}
+
+// Snapshot fetches the thermostats c exports metrics for, honoring its
+// options; data of disabled metric groups isn't requested and left empty.
+// Metrics are returned even if err is not nil, with whatever data the API
+// calls that succeeded returned.
+func (c *eCollector) Snapshot() (Metrics, error) {
+	m := c.cachedFetch().metrics
+	for _, call := range m.Calls {
+		if call.Err != nil {
+			return m, call.Err
+		}
+	}
+	return m, nil
+}
+
+// metrics parses the data of r for the thermostats c exports metrics for.
+func (c *eCollector) metrics(r fetchResult) Metrics {
+	m := Metrics{
+		FetchedAt: r.fetchedAt,
+		Calls: []Call{
+			{Name: "get_thermostats", Elapsed: r.thermostatsElapsed, Err: r.thermostatsErr},
+			{Name: "get_thermostat_summary", Elapsed: r.summaryElapsed, Err: r.summaryErr},
+		},
+	}
+	for _, t := range r.thermostats {
+		if c.collected(t.Identifier, t.Name) && (t.Runtime.Connected || !c.skipDisconnected) {
+			m.Thermostats = append(m.Thermostats, c.parseThermostat(t))
+		}
+	}
+	for _, t := range r.summary {
+		if c.collected(t.Identifier, t.Name) && (t.Connected || !c.skipDisconnected) {
+			m.Equipment = append(m.Equipment, EquipmentStatus{
+				ThermostatID:   t.Identifier,
+				ThermostatName: c.nameFormat.format(t.Name),
+				Running:        equipmentStates(t.EquipmentStatus),
+			})
+		}
+	}
+	// thermostats added between the two calls are missing from one of them,
+	// which only leaves out their equipment status or their other metrics
+	// until the next scrape
+	for _, t := range m.Thermostats {
+		if _, ok := r.summary[t.ID]; !ok && r.summaryErr == nil {
+			log.Debugf("thermostat %s is missing from the summary, skipping its equipment status", t.ID)
+		}
+	}
+	sort.Slice(m.Equipment, func(i, j int) bool {
+		return m.Equipment[i].ThermostatID < m.Equipment[j].ThermostatID
+	})
+	return m
+}
+
+// parseThermostat parses the data of a thermostat, logging and counting
+// values that can't be parsed.
+func (c *eCollector) parseThermostat(t ecobee.Thermostat) Thermostat {
+	source := "thermostat " + t.Identifier
+	// disconnected thermostats report the runtime they had last, or none
+	connected := t.Runtime.Connected
+	th := Thermostat{
+		ID:        t.Identifier,
+		Name:      c.nameFormat.format(t.Name),
+		Model:     t.ModelNumber,
+		Brand:     t.Brand,
+		Connected: t.Runtime.Connected,
+		HvacMode:  t.Settings.HvacMode,
+		Runtime: Runtime{
+			ActualTemperature: c.scaleTemperature(source+" actual temperature", float64(t.Runtime.ActualTemperature), connected),
+			DesiredHeat:       c.scaleSetpoint(source+" desired heat", t.Runtime.DesiredHeat, connected),
+			DesiredCool:       c.scaleSetpoint(source+" desired cool", t.Runtime.DesiredCool, connected),
+			ActualHumidity:    float64(t.Runtime.ActualHumidity),
+			DesiredHumidity:   float64(t.Runtime.DesiredHumidity),
+			DesiredDehumidity: float64(t.Runtime.DesiredDehumidity),
+			DesiredFanMode:    t.Runtime.DesiredFanMode,
+		},
+		Program: Program{CurrentClimate: t.Program.CurrentClimateRef},
+	}
+	th.Runtime.HeatRange = c.parseRange(source+" heat range", t.Runtime.DesiredHeatRange, connected)
+	th.Runtime.CoolRange = c.parseRange(source+" cool range", t.Runtime.DesiredCoolRange, connected)
+
+	// both times are part of every thermostat, regardless of the selection
+	if offset, err := thermostatOffset(t); err == nil {
+		th.TimeOffset = &offset
+	} else {
+		c.parseError("parse_runtime", fmt.Errorf("thermostat %s: bad thermostat time: %v", t.Identifier, err))
+	}
+
+	// the API reports runtime timestamps in UTC
+	if ts := t.Runtime.LastModified; ts != "" {
+		if modified, err := time.Parse(ecobeeTimeLayout, ts); err == nil {
+			th.LastModified = modified
+		} else {
+			c.parseError("parse_runtime", fmt.Errorf("thermostat %s: bad runtime timestamp: %v", t.Identifier, err))
+		}
+	}
+
+	if ts := t.ExtendedRuntime.LastReadingTimestamp; ts != "" {
+		if reading, err := time.Parse(ecobeeTimeLayout, ts); err == nil {
+			th.ExtendedRuntime = ExtendedRuntime{
+				LastReading: reading,
+				Intervals:   equipmentRuntime(t.ExtendedRuntime),
+			}
+		} else {
+			c.parseError("parse_runtime", fmt.Errorf("thermostat %s: bad extended runtime timestamp: %v", t.Identifier, err))
+		}
+	}
+
+	for _, cl := range t.Program.Climates {
+		climate := Climate{
+			Ref:          cl.ClimateRef,
+			Name:         cl.Name,
+			HeatSetpoint: c.scaleSetpoint(source+" climate "+cl.ClimateRef+" heat setpoint", cl.HeatTemp, true),
+			CoolSetpoint: c.scaleSetpoint(source+" climate "+cl.ClimateRef+" cool setpoint", cl.CoolTemp, true),
+		}
+		for _, s := range cl.Sensors {
+			climate.Sensors = append(climate.Sensors, climateSensorID(s.ID))
+		}
+		th.Program.Climates = append(th.Program.Climates, climate)
+	}
+	th.Program.Schedule = t.Program.Schedule
+	th.Program.Hash = programHash(th.Program)
+	for _, e := range runningEvents(t) {
+		ev := Event{Type: e.Type, ClimateRef: e.HoldClimateRef, Fan: e.Fan}
+		if th.TimeOffset != nil {
+			if end, err := eventEnd(e, *th.TimeOffset); err == nil {
+				ev.End = end
+			} else {
+				c.parseError("parse_events", fmt.Errorf("thermostat %s: bad %s event end: %v", t.Identifier, e.Type, err))
+			}
+		}
+		th.Program.Events = append(th.Program.Events, ev)
+	}
+
+	// the first forecast describes current conditions
+	if len(t.Weather.Forecasts) > 0 {
+		w := t.Weather.Forecasts[0]
+		th.Weather = &Weather{
+			Temperature: c.scaleTemperature(source+" outdoor temperature", float64(w.Temperature), true),
+			Humidity:    float64(w.RelativeHumidity),
+			WindSpeed:   float64(w.WindSpeed) / 1000,
+			Pressure:    float64(w.Pressure),
+		}
+	}
+
+	for _, s := range t.RemoteSensors {
+		th.Sensors = append(th.Sensors, c.parseSensor(t.Identifier, s))
+	}
+	return th
+}
+
+// climateSensorID returns the identifier of a sensor listed by a climate,
+// which the API suffixes with the identifier of a capability, e.g. "rs:100:1"
+// for sensor "rs:100".
+func climateSensorID(id string) string {
+	if strings.Count(id, ":") < 2 {
+		return id
+	}
+	return id[:strings.LastIndex(id, ":")]
+}
+
+// programHash returns a hash of the schedule and climates of p.
+func programHash(p Program) string {
+	h := fnv.New64a()
+	for _, day := range p.Schedule {
+		fmt.Fprintf(h, "%q\n", day)
+	}
+	for _, cl := range p.Climates {
+		fmt.Fprintf(h, "%q %q %v %v\n", cl.Ref, cl.Name, cl.HeatSetpoint, cl.CoolSetpoint)
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// parseSensor parses the readings of a sensor of the thermostat with the
+// given identifier, logging and counting values that can't be parsed.
+func (c *eCollector) parseSensor(thermostatID string, s ecobee.RemoteSensor) Sensor {
+	source := fmt.Sprintf("thermostat %s sensor %s", thermostatID, s.ID)
+	sensor := Sensor{
+		ID:     s.ID,
+		Name:   c.nameFormat.format(s.Name),
+		Type:   s.Type,
+		InUse:  s.InUse,
+		Online: sensorOnline(s),
+	}
+	for _, sc := range s.Capability {
+		if !capabilityReported(sc) {
+			continue
+		}
+		switch sc.Type {
+		case "temperature":
+			if v, ok := c.parseCapability(source, sc, "parse_temperature"); ok {
+				v = c.scaleTemperature(source+" temperature", v, true)
+				sensor.Temperature = &v
+			}
+		case "humidity":
+			sensor.Humidity = c.parseOptionalCapability(source, sc, "parse_humidity")
+		case "occupancy":
+			if v, err := parseOccupancy(sc.Value); err == nil {
+				sensor.Occupied = &v
+			} else {
+				c.sensorParseError("parse_occupancy", sc.Type, fmt.Errorf("%s: %v", source, err))
+			}
+		case "airQuality":
+			sensor.AirQuality = c.parseOptionalCapability(source, sc, "parse_air_quality")
+		case "co2", "co2PPM":
+			sensor.CO2 = c.parseOptionalCapability(source, sc, "parse_air_quality")
+		case "vocPPM": // reported in parts per billion despite the name
+			sensor.VOC = c.parseOptionalCapability(source, sc, "parse_air_quality")
+		default:
+			if v, err := strconv.ParseFloat(sc.Value, 64); err == nil {
+				if sensor.Capabilities == nil {
+					sensor.Capabilities = map[string]float64{}
+				}
+				sensor.Capabilities[sc.Type] = v
+			} else {
+				log.Debugf("%s: ignoring capability %q with value %q", source, sc.Type, sc.Value)
+			}
+		}
+	}
+	return sensor
+}
+
+// parseCapability parses the numeric value of a capability of the sensor
+// described by source, logging and counting values that can't be parsed
+// under stage.
+func (c *eCollector) parseCapability(source string, sc ecobee.RemoteSensorCapability, stage string) (float64, bool) {
+	v, err := strconv.ParseFloat(sc.Value, 64)
+	if err != nil {
+		c.sensorParseError(stage, sc.Type, fmt.Errorf("%s: bad %s: %v", source, sc.Type, err))
+		return 0, false
+	}
+	return v, true
+}
+
+// parseOptionalCapability is like parseCapability, returning nil for values
+// that can't be parsed.
+func (c *eCollector) parseOptionalCapability(source string, sc ecobee.RemoteSensorCapability, stage string) *float64 {
+	v, ok := c.parseCapability(source, sc, stage)
+	if !ok {
+		return nil
+	}
+	return &v
+}
+
+// parseError logs and counts an error encountered while parsing at stage.
+func (c *eCollector) parseError(stage string, err error) {
+	log.Error(err)
+	c.scrapeErrors.WithLabelValues(stage).Inc()
+}
+
+// sensorParseError is like parseError for a reading of a single sensor, which
+// is only logged as a warning: remote sensors with flat batteries or
+// ones that are out of range report bad readings routinely.
+func (c *eCollector) sensorParseError(stage, sensorType string, err error) {
+	log.Warn(err)
+	c.scrapeErrors.WithLabelValues(stage).Inc()
+	c.sensorParseErrors.WithLabelValues(sensorType).Inc()
+}
+
+// Temperatures in degrees Fahrenheit beyond the extremes recorded on earth,
+// which only a temperature scaled wrongly, e.g. 7050 rather than 705 tenths,
+// falls outside of.
+const (
+	minPlausibleTemperature = -80
+	maxPlausibleTemperature = 180
+)
+
+// scaleTemperature scales a temperature of source in tenths of degrees
+// Fahrenheit, as the API reports all temperatures, into degrees Fahrenheit.
+// When checked, results outside of the plausible range are logged and
+// counted, as they hint at a change in how the API encodes temperatures, but
+// still returned.
+func (c *eCollector) scaleTemperature(source string, tenths float64, checked bool) float64 {
+	f := tenths / 10
+	if checked && (f < minPlausibleTemperature || f > maxPlausibleTemperature) {
+		log.Warnf("%s: implausible temperature %v°F", source, f)
+		c.scrapeErrors.WithLabelValues("implausible_temperature").Inc()
+	}
+	return f
+}
+
+// scaleSetpoint scales a setpoint like scaleTemperature, leaving the 0 the API
+// reports for missing setpoints unchecked.
+func (c *eCollector) scaleSetpoint(source string, tenths int, checked bool) float64 {
+	return c.scaleTemperature(source, float64(tenths), checked && tenths != 0)
+}
+
+// parseRange scales a setpoint range of source, returning nil unless it has
+// both a low and a high end.
+func (c *eCollector) parseRange(source string, r []int, checked bool) []float64 {
+	if len(r) != 2 {
+		return nil
+	}
+	return []float64{
+		c.scaleSetpoint(source+" low", r[0], checked),
+		c.scaleSetpoint(source+" high", r[1], checked),
+	}
+}
+
+// rawTemperature returns a temperature in the tenths of degrees Fahrenheit
+// the API reports it in.
+func rawTemperature(f float64) float64 {
+	return math.Round(f * 10)
+}
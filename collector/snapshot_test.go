@@ -0,0 +1,177 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/billykwooten/go-ecobee/ecobee"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseThermostatWithoutSensors(t *testing.T) {
+	c := newTestCollector(t, nil)
+	th := c.parseThermostat(ecobee.Thermostat{Identifier: "1", Name: "Home"})
+	if len(th.Sensors) != 0 {
+		t.Fatalf("got %d sensors, want none", len(th.Sensors))
+	}
+}
+
+func TestParseOnboardSensorWithoutReadings(t *testing.T) {
+	c := newTestCollector(t, nil)
+	th := c.parseThermostat(ecobee.Thermostat{
+		Identifier: "1",
+		Name:       "Home",
+		RemoteSensors: []ecobee.RemoteSensor{{
+			ID:   "ei:0",
+			Name: "Home",
+			Type: "thermostat",
+			Capability: []ecobee.RemoteSensorCapability{
+				{ID: "1", Type: "temperature", Value: "unknown"},
+				{ID: "2", Type: "humidity", Value: ""},
+				{ID: "3", Type: "occupancy", Value: ""},
+			},
+		}},
+	})
+	if len(th.Sensors) != 1 {
+		t.Fatalf("got %d sensors, want 1", len(th.Sensors))
+	}
+	s := th.Sensors[0]
+	if s.Online {
+		t.Error("sensor without readings is online")
+	}
+	if s.Temperature != nil || s.Humidity != nil || s.Occupied != nil {
+		t.Errorf("got readings %v, %v, %v, want none", s.Temperature, s.Humidity, s.Occupied)
+	}
+	for _, stage := range []string{"parse_temperature", "parse_humidity", "parse_occupancy"} {
+		if v := testutil.ToFloat64(c.scrapeErrors.WithLabelValues(stage)); v != 0 {
+			t.Errorf("got %v %s errors, want none", v, stage)
+		}
+	}
+}
+
+func TestParseOnboardSensor(t *testing.T) {
+	c := newTestCollector(t, nil)
+	th := c.parseThermostat(ecobee.Thermostat{
+		Identifier: "1",
+		Name:       "Home",
+		RemoteSensors: []ecobee.RemoteSensor{{
+			ID:   "ei:0",
+			Name: "Home",
+			Type: "thermostat",
+			Capability: []ecobee.RemoteSensorCapability{
+				{ID: "1", Type: "temperature", Value: "715"},
+				{ID: "2", Type: "humidity", Value: "41"},
+				{ID: "3", Type: "occupancy", Value: "false"},
+			},
+		}},
+	})
+	s := th.Sensors[0]
+	if !s.Online {
+		t.Error("sensor with readings is offline")
+	}
+	if s.Temperature == nil || *s.Temperature != 71.5 {
+		t.Errorf("got temperature %v, want 71.5", s.Temperature)
+	}
+	if s.Humidity == nil || *s.Humidity != 41 {
+		t.Errorf("got humidity %v, want 41", s.Humidity)
+	}
+	if s.Occupied == nil || *s.Occupied {
+		t.Errorf("got occupancy %v, want false", s.Occupied)
+	}
+}
+
+func TestParseSensorErrors(t *testing.T) {
+	c := newTestCollector(t, nil)
+	th := c.parseThermostat(ecobee.Thermostat{
+		Identifier: "1",
+		Name:       "Home",
+		RemoteSensors: []ecobee.RemoteSensor{{
+			ID:   "rs:100",
+			Name: "Bedroom",
+			Type: "ecobee3_remote_sensor",
+			Capability: []ecobee.RemoteSensorCapability{
+				{ID: "1", Type: "temperature", Value: "warm"},
+				{ID: "2", Type: "occupancy", Value: "maybe"},
+			},
+		}},
+	})
+	s := th.Sensors[0]
+	if s.Temperature != nil || s.Occupied != nil {
+		t.Errorf("got readings %v, %v, want none", s.Temperature, s.Occupied)
+	}
+	for _, typ := range []string{"temperature", "occupancy"} {
+		if v := testutil.ToFloat64(c.sensorParseErrors.WithLabelValues(typ)); v != 1 {
+			t.Errorf("got %v %s parse errors, want 1", v, typ)
+		}
+	}
+}
+
+func TestImplausibleTemperature(t *testing.T) {
+	c := newTestCollector(t, nil)
+	th := testThermostat()
+	th.Runtime.ActualTemperature = 7050 // 70.5°F scaled once too few
+	c.parseThermostat(th)
+	if v := testutil.ToFloat64(c.scrapeErrors.WithLabelValues("implausible_temperature")); v != 1 {
+		t.Errorf("got %v implausible_temperature errors, want 1", v)
+	}
+	if v := testutil.ToFloat64(c.scrapeErrors.WithLabelValues("parse_temperature")); v != 0 {
+		t.Errorf("got %v parse_temperature errors, want none", v)
+	}
+}
+
+func TestPlausibleTemperatures(t *testing.T) {
+	c := newTestCollector(t, nil)
+	th := testThermostat()
+	th.Runtime.DesiredCool = 0
+	th.RemoteSensors = []ecobee.RemoteSensor{{
+		ID:         "rs:100",
+		Name:       "Garage",
+		Type:       "ecobee3_remote_sensor",
+		Capability: []ecobee.RemoteSensorCapability{{ID: "1", Type: "temperature", Value: "200"}},
+	}}
+	disconnected := ecobee.Thermostat{Identifier: "2", Name: "Cabin"}
+	disconnected.Runtime.ActualTemperature = 7050
+	for _, raw := range []ecobee.Thermostat{th, disconnected} {
+		c.parseThermostat(raw)
+	}
+	if v := testutil.ToFloat64(c.scrapeErrors.WithLabelValues("implausible_temperature")); v != 0 {
+		t.Errorf("got %v implausible_temperature errors, want none", v)
+	}
+}
+
+func TestTemperaturePrecision(t *testing.T) {
+	c := newTestCollector(t, nil)
+	for tenths := -400; tenths <= 1500; tenths++ {
+		f := c.scaleTemperature("test", float64(tenths), true)
+		if raw := rawTemperature(f); raw != float64(tenths) {
+			t.Fatalf("%d tenths: got raw value %v back", tenths, raw)
+		}
+		// exposition formats floats with the shortest exact representation
+		got := strconv.FormatFloat(f, 'g', -1, 64)
+		want := strconv.FormatFloat(float64(tenths)/10, 'f', -1, 64)
+		if got != want {
+			t.Fatalf("%d tenths: got %s, want %s", tenths, got, want)
+		}
+	}
+}
+
+func TestCallJSON(t *testing.T) {
+	for _, tt := range []struct {
+		call Call
+		want string
+	}{
+		{Call{Name: "get_thermostats", Elapsed: time.Second}, `{"Name":"get_thermostats","Elapsed":1000000000,"Err":null}`},
+		{Call{Name: "get_thermostats", Err: errors.New("boom")}, `{"Name":"get_thermostats","Elapsed":0,"Err":"boom"}`},
+	} {
+		b, err := json.Marshal(tt.call)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("got %s, want %s", b, tt.want)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package collector
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"golang.org/x/oauth2"
+)
+
+// readCachedToken reads the OAuth2 token go-ecobee persists in its cache
+// file. Reading the file rather than asking the client's token source for a
+// token never triggers a refresh or the interactive PIN authorization.
+func readCachedToken(cacheFile string) (*oauth2.Token, error) {
+	b, err := ioutil.ReadFile(cacheFile)
+	if err != nil {
+		return nil, err
+	}
+	var tok oauth2.Token
+	if err := json.Unmarshal(b, &tok); err != nil {
+		return nil, err
+	}
+	return &tok, nil
+}
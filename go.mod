@@ -5,6 +5,8 @@ go 1.16
 require (
 	github.com/billykwooten/go-ecobee v0.0.1
 	github.com/prometheus/client_golang v1.10.0
+	github.com/prometheus/common v0.18.0
 	github.com/sirupsen/logrus v1.8.1
+	golang.org/x/oauth2 v0.0.0-20210402161424-2e8d93401602
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
 )
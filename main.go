@@ -14,8 +14,12 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -23,31 +27,263 @@ import (
 	"github.com/billykwooten/go-ecobee/ecobee"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/prometheus/common/expfmt"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
+// Version is the version of the exporter, set at build time.
+var Version = "dev"
+
 var (
 	app            = kingpin.New("ecobee-exporter", "Ecobee Exporter utilizing Ecobee API").Author("Billy Wooten")
 	addr           = app.Flag("listen-address", "HTTP port to listen on").Envar("ECOBEE_LISTEN_ADDRESS").Default(":9098").String()
 	applicationKey = app.Flag("appkey", "Application API Key").Envar("ECOBEE_APPKEY").Default("p3NbLx6iSYTjXDFHIMtM77SWWPLRuEZ0").String()
 	cacheFile      = app.Flag("cachefile", "Cache file so the exporter can store and sync authorization tokens").Envar("ECOBEE_CACHEFILE").Default("/db/auth.cache").String()
+	accounts       = app.Flag("account", "Ecobee account to collect from as name=cachefile, can be repeated; replaces cachefile and adds an account label to all metrics").Envar("ECOBEE_ACCOUNTS").StringMap()
+	userAgent      = app.Flag("user-agent", "User-Agent of Ecobee API requests").Envar("ECOBEE_USER_AGENT").Default("ecobee-exporter/" + Version).String()
+	timeout        = app.Flag("timeout", "Maximum time to wait for the Ecobee API during a scrape, 0 to wait indefinitely").Envar("ECOBEE_TIMEOUT").Default("0s").Duration()
+	retryAttempts  = app.Flag("retry-attempts", "Number of attempts made for every Ecobee API call").Envar("ECOBEE_RETRY_ATTEMPTS").Default("1").Int()
+	retryDelay     = app.Flag("retry-delay", "Delay before retrying a failed Ecobee API call, doubled for every following retry").Envar("ECOBEE_RETRY_DELAY").Default("1s").Duration()
+	concurrency    = app.Flag("concurrency", "Maximum number of Ecobee API calls made at the same time during a scrape").Envar("ECOBEE_CONCURRENCY").Default("1").Int()
+	cacheTTL       = app.Flag("cache-ttl", "How long to reuse Ecobee API responses for, 0 to fetch on every scrape").Envar("ECOBEE_CACHE_TTL").Default("0s").Duration()
+	include        = app.Flag("thermostat-include", "Only export thermostats whose identifier or name matches this regular expression").Envar("ECOBEE_THERMOSTAT_INCLUDE").Regexp()
+	exclude        = app.Flag("thermostat-exclude", "Don't export thermostats whose identifier or name matches this regular expression").Envar("ECOBEE_THERMOSTAT_EXCLUDE").Regexp()
+	selectionType  = app.Flag("selection-type", "Ecobee API selection type of the thermostats to collect from, such as registered, thermostats or managementSet").Envar("ECOBEE_SELECTION_TYPE").Default("registered").String()
+	selectionMatch = app.Flag("selection-match", "Ecobee API selection match for the selection type, such as thermostat identifiers or a management set path").Envar("ECOBEE_SELECTION_MATCH").String()
+	disabled       = app.Flag("disable-metrics", "Metric group not to export (runtime, sensors, equipment, program or weather), can be repeated").Envar("ECOBEE_DISABLE_METRICS").Enums(metricGroups()...)
+	skipOnboard    = app.Flag("skip-onboard-sensor", "Leave the sensor built into thermostats out of sensor metrics, its temperature is still exported as onboard_temperature").Envar("ECOBEE_SKIP_ONBOARD_SENSOR").Bool()
+	skipOffline    = app.Flag("skip-disconnected", "Leave thermostats disconnected from the Ecobee servers out of all metrics, including thermostat_connected").Envar("ECOBEE_SKIP_DISCONNECTED").Bool()
+	dropLabels     = app.Flag("drop-sensor-label", "Label to drop from sensor metrics (thermostat_name, sensor_name or sensor_type), can be repeated; sensor_info keeps them").Envar("ECOBEE_DROP_SENSOR_LABELS").Enums(collector.DroppableSensorLabels...)
+	nameFormat     = app.Flag("name-format", "Format of thermostat and sensor names in labels (raw, clean to strip non-printable characters, or slug)").Envar("ECOBEE_NAME_FORMAT").Default(string(collector.RawNames)).Enum(string(collector.RawNames), string(collector.CleanNames), string(collector.SlugNames))
+	snapshotJSON   = app.Flag("snapshot-json", "Serve the collected thermostats as JSON on /snapshot.json").Envar("ECOBEE_SNAPSHOT_JSON").Bool()
+	once           = app.Flag("once", "Collect metrics once, print them to stdout in the text exposition format and exit").Envar("ECOBEE_ONCE").Bool()
+	pushGateway    = app.Flag("push-gateway", "Pushgateway URL to push metrics to on an interval instead of serving them").Envar("ECOBEE_PUSH_GATEWAY").URL()
+	pushInterval   = app.Flag("push-interval", "Interval to push metrics to the Pushgateway at").Envar("ECOBEE_PUSH_INTERVAL").Default("1m").Duration()
+	pushJob        = app.Flag("push-job", "Job label of metrics pushed to the Pushgateway").Envar("ECOBEE_PUSH_JOB").Default("ecobee").String()
+	pushInstance   = app.Flag("push-instance", "Instance label of metrics pushed to the Pushgateway, the hostname if empty").Envar("ECOBEE_PUSH_INSTANCE").String()
+	unitSuffixes   = app.Flag("unit-suffixes", "Append units to the names of temperature, humidity, weather and duration metrics, e.g. actual_temperature_fahrenheit").Envar("ECOBEE_UNIT_SUFFIXES").Bool()
+	logLevel       = app.Flag("log-level", "Minimum level of log messages (debug, info, warning or error)").Envar("ECOBEE_LOG_LEVEL").Default("info").Enum("debug", "info", "warning", "error")
+	logFormat      = app.Flag("log-format", "Format of log messages (text or json)").Envar("ECOBEE_LOG_FORMAT").Default("text").Enum("text", "json")
+	tempUnit       = app.Flag("temperature-unit", "Unit to export temperatures in (fahrenheit, celsius, or both with a unit label)").Envar("ECOBEE_TEMPERATURE_UNIT").Default(string(collector.Fahrenheit)).Enum(string(collector.Fahrenheit), string(collector.Celsius), string(collector.BothUnits))
 )
 
 func main() {
 	// Parse Kingpin Variables
 	kingpin.MustParse(app.Parse(os.Args[1:]))
 
+	// Setup Logging
+	level, err := log.ParseLevel(*logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.SetLevel(level)
+	if *logFormat == "json" {
+		log.SetFormatter(&log.JSONFormatter{})
+	}
+
 	// Setup Scopes for API Requests
 	ecobee.Scopes = []string{"smartRead"}
 
-	//Create a new instance of the ecobeeCollector and
+	//Create a new instance of the ecobeeCollector for every account and
 	//register it with the prometheus client.
-	ecobeeCollector := collector.NewEcobeeCollector(ecobee.NewClient(*applicationKey, *cacheFile), "ecobee")
-	prometheus.MustRegister(ecobeeCollector)
+	//One-shot collections and pushes use their own registry, leaving out
+	//the metrics of the exporter process.
+	var registerer prometheus.Registerer = prometheus.DefaultRegisterer
+	registry := prometheus.NewRegistry()
+	if *once || *pushGateway != nil {
+		registerer = registry
+	}
+	//Internal metrics are registered separately as well, to be served
+	//without waiting for the Ecobee API.
+	internal := prometheus.NewRegistry()
+	var collectors []ecobeeCollector
+	named := map[string]ecobeeCollector{}
+	if len(*accounts) == 0 {
+		c, err := newCollector(*cacheFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		registerer.MustRegister(c)
+		internal.MustRegister(c.Internal())
+		collectors = append(collectors, c)
+	}
+	for name, file := range *accounts {
+		c, err := newCollector(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		labels := prometheus.Labels{"account": name}
+		prometheus.WrapRegistererWith(labels, registerer).MustRegister(c)
+		prometheus.WrapRegistererWith(labels, internal).MustRegister(c.Internal())
+		collectors = append(collectors, c)
+		named[name] = c
+	}
+	if *once {
+		if err := writeMetrics(os.Stdout, registry); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *pushGateway != nil {
+		pushMetrics(registry)
+		return
+	}
 
 	//This section will start the HTTP server and expose
 	//any metrics on the /metrics endpoint.
 	http.Handle("/metrics", promhttp.Handler())
+	http.Handle("/internal/metrics", promhttp.HandlerFor(internal, promhttp.HandlerOpts{}))
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		for _, c := range collectors {
+			if err := c.Healthy(); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	if *snapshotJSON {
+		http.HandleFunc("/snapshot.json", func(w http.ResponseWriter, r *http.Request) {
+			//A single account is served as is, multiple ones by name.
+			var v interface{}
+			if len(named) == 0 {
+				v, _ = collectors[0].Snapshot()
+			} else {
+				snapshots := map[string]collector.Metrics{}
+				for name, c := range named {
+					snapshots[name], _ = c.Snapshot()
+				}
+				v = snapshots
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(v); err != nil {
+				log.Error(err)
+			}
+		})
+	}
 	log.Info("Beginning to serve on port " + *addr)
 	log.Fatal(http.ListenAndServe(*addr, nil))
 }
+
+// ecobeeCollector is a collector created by newCollector.
+type ecobeeCollector interface {
+	prometheus.Collector
+	Healthy() error
+	Internal() prometheus.Collector
+	Snapshot() (collector.Metrics, error)
+}
+
+// newCollector creates an ecobeeCollector for the account whose tokens are
+// stored in cacheFile.
+func newCollector(cacheFile string) (ecobeeCollector, error) {
+	client := ecobee.NewClient(*applicationKey, cacheFile)
+	// also bound the requests themselves, which keep running after a scrape times out
+	client.Timeout = *timeout
+	client.Transport = &userAgentTransport{userAgent: *userAgent, next: client.Transport}
+	opts := []collector.Option{
+		collector.WithTemperatureUnit(collector.TemperatureUnit(*tempUnit)),
+		collector.WithNameFormat(collector.NameFormat(*nameFormat)),
+		collector.WithTokenCacheFile(cacheFile),
+		collector.WithTimeout(*timeout),
+		collector.WithRetries(*retryAttempts, *retryDelay),
+		collector.WithConcurrency(*concurrency),
+		collector.WithCacheTTL(*cacheTTL),
+		collector.WithThermostatFilter(*include, *exclude),
+		collector.WithSelection(*selectionType, *selectionMatch),
+		collector.WithoutMetricGroups(disabledGroups()...),
+		collector.WithoutSensorLabels(*dropLabels...),
+	}
+	if *skipOnboard {
+		opts = append(opts, collector.WithoutOnboardSensor())
+	}
+	if *skipOffline {
+		opts = append(opts, collector.WithoutDisconnectedThermostats())
+	}
+	if *unitSuffixes {
+		opts = append(opts, collector.WithUnitSuffixes())
+	}
+	return collector.NewEcobeeCollector(client, "ecobee", opts...)
+}
+
+// userAgentTransport sets the User-Agent of every request made through it.
+// It wraps the OAuth2 transport of the go-ecobee client, which refreshes
+// tokens with its own client, so those requests keep the default User-Agent.
+type userAgentTransport struct {
+	userAgent string
+	next      http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the request they're given
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.next.RoundTrip(req)
+}
+
+// writeMetrics gathers the metrics of g and writes them to w in the text
+// exposition format.
+func writeMetrics(w io.Writer, g prometheus.Gatherer) error {
+	mfs, err := g.Gather()
+	if err != nil {
+		return err
+	}
+	enc := expfmt.NewEncoder(w, expfmt.FmtText)
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pushMetrics pushes the metrics of registry to the Pushgateway every push
+// interval, forever. Failed pushes are logged and counted in a metric pushed
+// along with the next attempt.
+func pushMetrics(registry *prometheus.Registry) {
+	pushErrors := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "ecobee",
+		Name:      "push_errors_total",
+		Help:      "failed pushes to the Pushgateway",
+	})
+	registry.MustRegister(pushErrors)
+
+	instance := *pushInstance
+	if instance == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Fatal(err)
+		}
+		instance = hostname
+	}
+	pusher := push.New((*pushGateway).String(), *pushJob).
+		Grouping("instance", instance).
+		Gatherer(registry)
+
+	log.Infof("Pushing metrics to %s every %s", *pushGateway, *pushInterval)
+	for {
+		if err := pusher.Push(); err != nil {
+			log.Error(err)
+			pushErrors.Inc()
+		}
+		time.Sleep(*pushInterval)
+	}
+}
+
+// metricGroups returns the names of every collector.MetricGroup.
+func metricGroups() []string {
+	var groups []string
+	for _, g := range collector.MetricGroups {
+		groups = append(groups, string(g))
+	}
+	return groups
+}
+
+// disabledGroups returns the metric groups disabled on the command line.
+func disabledGroups() []collector.MetricGroup {
+	var groups []collector.MetricGroup
+	for _, g := range *disabled {
+		groups = append(groups, collector.MetricGroup(g))
+	}
+	return groups
+}